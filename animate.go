@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/tdewolff/canvas"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"io"
+	"math"
+	"path/filepath"
+	"time"
+)
+
+const ringSegments = 24
+
+// animations are the names drawAnimationFrame accepts.
+var animations = map[string]bool{"ring": true, "ripple": true, "pulse": true}
+
+// animationEncoders write the frames of animated cards by -animate format.
+var animationEncoders = map[string]func(w io.Writer, imgs []*image.RGBA, delay time.Duration) error{
+	"gif":  encodeGIF,
+	"apng": encodeAPNG,
+}
+
+// pulseDepth is how much the pulse animation darkens the background at its
+// peak, halfway through the loop.
+const pulseDepth = 0.12
+
+// pulseBackground darkens background by a pulse t of the way through its loop,
+// keeping its opacity.
+func pulseBackground(background color.RGBA, t float64) color.RGBA {
+	n := color.NRGBAModel.Convert(background).(color.NRGBA)
+	d := darken(color.NRGBA{R: n.R, G: n.G, B: n.B, A: 255}, pulseDepth*(1-math.Cos(2*math.Pi*t))/2)
+	return color.RGBAModel.Convert(color.NRGBA{R: d.R, G: d.G, B: d.B, A: n.A}).(color.RGBA)
+}
+
+// drawAnimationFrame draws the overlay of animation t of the way through its
+// loop. pulse has none, as it redraws the card instead.
+func drawAnimationFrame(ctx *canvas.Context, animation string, t float64) (err error) {
+	b, c := layout.Badge, color.NRGBAModel.Convert(gray).(color.NRGBA)
+	switch animation {
+	case "ring":
+		for i := 0; i < ringSegments; i++ {
+			theta := 360.0 * float64(i) / ringSegments
+			behind := math.Mod(360.0*t-theta+720.0, 360.0) / 360.0
+			ctx.SetFillColor(color.NRGBA{R: c.R, G: c.G, B: c.B, A: uint8(float64(c.A) * (1 - behind))})
+			ctx.DrawPath(b.X, b.Y, annularSector(b.Radius+2, b.Radius+8, theta, theta+360.0/ringSegments))
+		}
+	case "ripple":
+		ctx.SetFillColor(color.NRGBA{R: c.R, G: c.G, B: c.B, A: uint8(float64(c.A) / 255 * 153 * (1 - t))})
+		ctx.DrawPath(b.X, b.Y, annularSector(b.Radius, b.Radius+16*t+0.5, 0, 360))
+	case "pulse":
+	default:
+		err = fmt.Errorf("unknown animation: %s", animation)
+	}
+	return
+}
+
+// renderAnimation rasterizes the frames of animation over the card c. The
+// pulse animation redraws the card over each frame's background with redraw.
+func renderAnimation(c *canvas.Canvas, redraw func(background color.RGBA) (*canvas.Canvas, error), animation string, frames int) (imgs []*image.RGBA, err error) {
+	if frames < 1 {
+		err = errors.New("animation needs at least one frame")
+		return
+	}
+	var base *image.RGBA
+	if base, err = rasterizeCard(context.Background(), c); err != nil {
+		return
+	}
+	defer putRGBA(base)
+	for i := 0; i < frames; i++ {
+		t := float64(i) / float64(frames)
+		img := image.NewRGBA(base.Bounds())
+		if animation == "pulse" {
+			var card *canvas.Canvas
+			if card, err = redraw(pulseBackground(optBackground, t)); err != nil {
+				return
+			}
+			var frame *image.RGBA
+			if frame, err = rasterizeCard(context.Background(), card); err != nil {
+				return
+			}
+			draw.Draw(img, img.Bounds(), frame, image.Point{}, draw.Src)
+			putRGBA(frame)
+			imgs = append(imgs, img)
+			continue
+		}
+		w, h := c.Size()
+		overlay := canvas.New(w, h)
+		if err = drawAnimationFrame(canvas.NewContext(overlay), animation, t); err != nil {
+			return
+		}
+		draw.Draw(img, img.Bounds(), base, image.Point{}, draw.Src)
+		var layer *image.RGBA
+		if layer, err = rasterizeCard(context.Background(), overlay); err != nil {
+			return
+		}
+		draw.Draw(img, img.Bounds(), layer, image.Point{}, draw.Over)
+		putRGBA(layer)
+		imgs = append(imgs, img)
+	}
+	return
+}
+
+func generateAnimation(id, name, address string, c *canvas.Canvas) (err error) {
+	var logo image.Image
+	if logo, err = loadLogo(id); err != nil {
+		return
+	}
+	redraw := func(background color.RGBA) (*canvas.Canvas, error) {
+		return composeCardOn(logo, name, address, background)
+	}
+	var imgs []*image.RGBA
+	if imgs, err = renderAnimation(c, redraw, optAnimation, optFrames); err != nil {
+		return
+	}
+	delay := optDuration / time.Duration(len(imgs))
+
+	encode, ok := animationEncoders[optAnimate]
+	if !ok {
+		return fmt.Errorf("unknown animation format: %s", optAnimate)
+	}
+	return writeFile(filepath.Join("dist", id+"."+optAnimate), func(w io.Writer) error {
+		return encode(w, imgs, delay)
+	})
+}
+
+func encodeGIF(w io.Writer, imgs []*image.RGBA, delay time.Duration) error {
+	g := &gif.GIF{}
+	for _, img := range imgs {
+		p := image.NewPaletted(img.Bounds(), palette.Plan9)
+		draw.FloydSteinberg.Draw(p, p.Bounds(), img, image.Point{})
+		g.Image = append(g.Image, p)
+		g.Delay = append(g.Delay, int(delay/(10*time.Millisecond)))
+	}
+	return gif.EncodeAll(w, g)
+}
+
+type pngChunk struct {
+	Type string
+	Data []byte
+}
+
+func readPNGChunks(buf []byte) (chunks []pngChunk, err error) {
+	if len(buf) < 8 {
+		err = errors.New("png: truncated signature")
+		return
+	}
+	buf = buf[8:]
+	for len(buf) >= 12 {
+		n := binary.BigEndian.Uint32(buf[:4])
+		if uint32(len(buf)-12) < n {
+			err = errors.New("png: truncated chunk")
+			return
+		}
+		chunks = append(chunks, pngChunk{Type: string(buf[4:8]), Data: buf[8 : 8+n]})
+		buf = buf[12+n:]
+	}
+	return
+}
+
+func writePNGChunk(w io.Writer, typ string, data []byte) (err error) {
+	var header [8]byte
+	binary.BigEndian.PutUint32(header[:4], uint32(len(data)))
+	copy(header[4:], typ)
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(data)
+	if _, err = w.Write(header[:]); err != nil {
+		return
+	}
+	if _, err = w.Write(data); err != nil {
+		return
+	}
+	err = binary.Write(w, binary.BigEndian, crc.Sum32())
+	return
+}
+
+func encodeAPNG(w io.Writer, imgs []*image.RGBA, delay time.Duration) (err error) {
+	if _, err = w.Write([]byte("\x89PNG\r\n\x1a\n")); err != nil {
+		return
+	}
+
+	var seq uint32
+	var ihdr []byte
+	for i, img := range imgs {
+		b := &bytes.Buffer{}
+		if err = pngEncoder.Encode(b, img); err != nil {
+			return
+		}
+		var chunks []pngChunk
+		if chunks, err = readPNGChunks(b.Bytes()); err != nil {
+			return
+		}
+
+		for _, chunk := range chunks {
+			if chunk.Type != "IHDR" {
+				continue
+			}
+			if i == 0 {
+				ihdr = chunk.Data
+				if err = writePNGChunk(w, "IHDR", ihdr); err != nil {
+					return
+				}
+				actl := make([]byte, 8)
+				binary.BigEndian.PutUint32(actl[0:], uint32(len(imgs)))
+				if err = writePNGChunk(w, "acTL", actl); err != nil {
+					return
+				}
+			} else if !bytes.Equal(ihdr, chunk.Data) {
+				return errors.New("apng: frames differ in size or color type")
+			}
+		}
+
+		size := img.Bounds().Size()
+		fctl := make([]byte, 26)
+		binary.BigEndian.PutUint32(fctl[0:], seq)
+		binary.BigEndian.PutUint32(fctl[4:], uint32(size.X))
+		binary.BigEndian.PutUint32(fctl[8:], uint32(size.Y))
+		binary.BigEndian.PutUint16(fctl[20:], uint16(delay/time.Millisecond))
+		binary.BigEndian.PutUint16(fctl[22:], 1000)
+		if err = writePNGChunk(w, "fcTL", fctl); err != nil {
+			return
+		}
+		seq++
+
+		for _, chunk := range chunks {
+			if chunk.Type != "IDAT" {
+				continue
+			}
+			if i == 0 {
+				err = writePNGChunk(w, "IDAT", chunk.Data)
+			} else {
+				fdat := make([]byte, 4+len(chunk.Data))
+				binary.BigEndian.PutUint32(fdat, seq)
+				copy(fdat[4:], chunk.Data)
+				err = writePNGChunk(w, "fdAT", fdat)
+				seq++
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	return writePNGChunk(w, "IEND", nil)
+}
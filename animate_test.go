@@ -0,0 +1,24 @@
+package main
+
+import (
+	"image/color"
+	"testing"
+)
+
+func TestPulseBackground(t *testing.T) {
+	white := color.RGBA{R: 255, G: 255, B: 255, A: 255}
+	if got := pulseBackground(white, 0); got != white {
+		t.Errorf("pulse starts at %v, want %v", got, white)
+	}
+	peak := pulseBackground(white, 0.5)
+	if want := darken(white, pulseDepth); peak != want {
+		t.Errorf("pulse peaks at %v, want %v", peak, want)
+	}
+	if a, b := pulseBackground(white, 0.25), pulseBackground(white, 0.75); a != b || a.R <= peak.R || a.R >= white.R {
+		t.Errorf("pulse at 0.25 and 0.75 = %v and %v, want the same between %v and %v", a, b, peak, white)
+	}
+	translucent := color.RGBA{R: 100, G: 0, B: 0, A: 100}
+	if got := pulseBackground(translucent, 0.5); got.A != translucent.A || got.R >= translucent.R {
+		t.Errorf("pulse of %v = %v, want it darker with the same alpha", translucent, got)
+	}
+}
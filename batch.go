@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/tdewolff/canvas"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+)
+
+// batchRow is one card of a batch file. Line numbers the rows from 1, counting
+// the header of CSV files. Formats, when set, overrides -formats for the row.
+type batchRow struct {
+	File    string `json:"-"`
+	Line    int    `json:"-"`
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	Formats string `json:"formats"`
+}
+
+// batchFile is what the -output template is executed with. Size is the pixel
+// size of the card at -resolution, like 600x800.
+type batchFile struct {
+	ID     string
+	Name   string
+	Format string
+	Size   string
+}
+
+// batchPathLocks serializes writes to the same file, so that rows of a batch
+// rendering to the same output path do not write over each other.
+type batchPathLocks struct {
+	mu    sync.Mutex
+	locks map[string]*batchPathLock
+}
+
+type batchPathLock struct {
+	sync.Mutex
+	users int
+}
+
+// lock waits until no other row is writing name and returns the function that
+// releases it.
+func (p *batchPathLocks) lock(name string) (unlock func()) {
+	name = filepath.Clean(name)
+	p.mu.Lock()
+	l, ok := p.locks[name]
+	if !ok {
+		l = &batchPathLock{}
+		p.locks[name] = l
+	}
+	l.users++
+	p.mu.Unlock()
+	l.Lock()
+	return func() {
+		l.Unlock()
+		p.mu.Lock()
+		if l.users--; l.users == 0 {
+			delete(p.locks, name)
+		}
+		p.mu.Unlock()
+	}
+}
+
+// readBatchCSV reads rows from a CSV file whose header names the id, name,
+// address and optional formats columns.
+func readBatchCSV(r io.Reader) (rows []batchRow, err error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	var header []string
+	if header, err = cr.Read(); err != nil {
+		return
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"id", "name", "address"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("missing %s column", name)
+		}
+	}
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return record[i]
+		}
+		return ""
+	}
+	for {
+		var record []string
+		if record, err = cr.Read(); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return
+		}
+		rows = append(rows, batchRow{
+			Line:    len(rows) + 2,
+			ID:      field(record, "id"),
+			Name:    field(record, "name"),
+			Address: field(record, "address"),
+			Formats: field(record, "formats"),
+		})
+	}
+}
+
+// readBatchJSONL reads one JSON object per line, skipping blank lines.
+func readBatchJSONL(r io.Reader) (rows []batchRow, err error) {
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<20)
+	for line := 1; s.Scan(); line++ {
+		if strings.TrimSpace(s.Text()) == "" {
+			continue
+		}
+		row := batchRow{Line: line}
+		if err = json.Unmarshal(s.Bytes(), &row); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		rows = append(rows, row)
+	}
+	err = s.Err()
+	return
+}
+
+func readBatch(name string) (rows []batchRow, err error) {
+	var f *os.File
+	if f, err = os.Open(name); err != nil {
+		return
+	}
+	defer f.Close()
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".csv":
+		rows, err = readBatchCSV(f)
+	case ".jsonl", ".ndjson":
+		rows, err = readBatchJSONL(f)
+	default:
+		err = errors.New("unknown batch file type, must be .csv, .jsonl or .ndjson")
+	}
+	if err != nil {
+		err = fmt.Errorf("%s: %w", name, err)
+	}
+	for i := range rows {
+		rows[i].File = name
+	}
+	return
+}
+
+// rowProgress returns a progress callback for renderRows that redraws a
+// progress bar on stderr when it is a terminal, and logs every row when it is
+// not. Failed rows are logged either way. finish ends the line of the bar.
+func rowProgress(command string) (progress func(done, total int, row batchRow, err error), finish func()) {
+	if fi, err := os.Stderr.Stat(); err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		progress = func(done, total int, row batchRow, err error) {
+			if err != nil {
+				log.Printf("%s: [%d/%d] %s failed: %s", command, done, total, row.ID, err)
+				return
+			}
+			log.Printf("%s: [%d/%d] %s", command, done, total, row.ID)
+		}
+		return progress, func() {}
+	}
+
+	const width = 30
+	mu := &sync.Mutex{}
+	shown := 0
+	progress = func(done, total int, row batchRow, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			fmt.Fprint(os.Stderr, "\r\033[K")
+			log.Printf("%s: %s failed: %s", command, row.ID, err)
+		}
+		// rows finish concurrently, so a later report may carry a lower count
+		if done > shown {
+			shown = done
+		}
+		filled := width * shown / total
+		fmt.Fprintf(os.Stderr, "\r\033[K%s: [%s%s] %d/%d %s", command, strings.Repeat("#", filled), strings.Repeat(".", width-filled), shown, total, row.ID)
+	}
+	finish = func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if shown > 0 {
+			fmt.Fprintln(os.Stderr)
+		}
+	}
+	return
+}
+
+// renderRows calls render for the rows on workers goroutines and reports each
+// finished row to progress. It returns a description of every row that
+// failed, and the error of ctx if it was done before all rows were started.
+func renderRows(ctx context.Context, rows []batchRow, workers int, render func(row batchRow) error, progress func(done, total int, row batchRow, err error)) (failures []string, err error) {
+	var done int64
+	mu := &sync.Mutex{}
+	queue := make(chan batchRow)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for row := range queue {
+				err := render(row)
+				n := atomic.AddInt64(&done, 1)
+				if err != nil {
+					mu.Lock()
+					failures = append(failures, fmt.Sprintf("%s:%d %s: %s", row.File, row.Line, row.ID, err))
+					mu.Unlock()
+				}
+				progress(int(n), len(rows), row, err)
+			}
+		}()
+	}
+loop:
+	for _, row := range rows {
+		select {
+		case queue <- row:
+		case <-ctx.Done():
+			break loop
+		}
+	}
+	close(queue)
+	wg.Wait()
+	err = ctx.Err()
+	return
+}
+
+func batchCard(row batchRow, output *template.Template, locks *batchPathLocks) (err error) {
+	id, name, address := normalizeItem(row.ID, row.Name, row.Address)
+	if err = validateItem(id, name, address); err != nil {
+		return
+	}
+	formats := optFormats
+	if row.Formats != "" {
+		formats = row.Formats
+	}
+	for _, format := range strings.Split(formats, ",") {
+		if _, ok := cardFormats[format]; !ok {
+			return &ValidationError{Field: "format", Value: format, Reason: "must be one of " + strings.Join(formatNames(), ", ")}
+		}
+	}
+
+	size := fmt.Sprintf("%dx%d", int(layout.Width*optResolution+0.5), int(layout.Height*optResolution+0.5))
+	var c *canvas.Canvas
+	for _, format := range strings.Split(formats, ",") {
+		buf := &strings.Builder{}
+		if err = output.Execute(buf, batchFile{ID: id, Name: name, Format: format, Size: size}); err != nil {
+			return
+		}
+		file := buf.String()
+		if err = os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return
+		}
+		unlock := locks.lock(file)
+		err = writeFile(file, func(w io.Writer) error {
+			return renderCard(w, &c, id, name, address, format)
+		})
+		unlock()
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func batch(args []string) (err error) {
+	fset := flag.NewFlagSet("batch", flag.ExitOnError)
+	workers := fset.Int("workers", runtime.NumCPU(), "number of concurrent renders")
+	outputText := fset.String("output", "dist/{{.ID}}.{{.Format}}", "template of the file names to write, with .ID, .Name, .Format and .Size")
+	if err = fset.Parse(args); err != nil {
+		return
+	}
+	if *workers < 1 {
+		return errors.New("batch: workers must be positive")
+	}
+	if fset.NArg() == 0 {
+		return errors.New("batch: no .csv or .jsonl files given")
+	}
+	var output *template.Template
+	if output, err = template.New("output").Option("missingkey=error").Parse(*outputText); err != nil {
+		return fmt.Errorf("batch: -output: %w", err)
+	}
+
+	var rows []batchRow
+	for _, name := range fset.Args() {
+		var fileRows []batchRow
+		if fileRows, err = readBatch(name); err != nil {
+			return
+		}
+		rows = append(rows, fileRows...)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	locks := &batchPathLocks{locks: map[string]*batchPathLock{}}
+	progress, finish := rowProgress("batch")
+	var failures []string
+	failures, err = renderRows(ctx, rows, *workers, func(row batchRow) error {
+		return batchCard(row, output, locks)
+	}, progress)
+	finish()
+	if optStats {
+		log.Println("stats total", statsSnapshot())
+	}
+	if err != nil {
+		return fmt.Errorf("batch: %w", err)
+	}
+	if len(failures) == 0 {
+		log.Printf("batch: rendered %d cards", len(rows))
+		return
+	}
+	for _, failure := range failures {
+		log.Println("batch: failed", failure)
+	}
+	return fmt.Errorf("batch: %d of %d cards failed", len(failures), len(rows))
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"image/color"
+	"math"
+	"strings"
+)
+
+// blockiesRand mirrors the xorshift generator of ethereum-blockies, including
+// the JavaScript number semantics of its seeding loop.
+type blockiesRand [4]int64
+
+func newBlockiesRand(seed string) *blockiesRand {
+	r := &blockiesRand{}
+	for i, c := range []byte(seed) {
+		r[i%4] = int64(int32(r[i%4])<<5) - r[i%4] + int64(c)
+	}
+	return r
+}
+
+func (r *blockiesRand) Float64() float64 {
+	t := int32(r[0]) ^ (int32(r[0]) << 11)
+	r[0], r[1], r[2] = r[1], r[2], r[3]
+	r3 := int32(r[3])
+	r[3] = int64(r3 ^ (r3 >> 19) ^ t ^ (t >> 8))
+	return float64(uint32(r[3])) / float64(uint32(1)<<31)
+}
+
+func (r *blockiesRand) Color() color.Color {
+	h := math.Floor(r.Float64() * 360)
+	s := float64(r.Float64()*60) + 40
+	l := (r.Float64() + r.Float64() + r.Float64() + r.Float64()) * 25
+	return hsl(h, s/100, l/100)
+}
+
+// blockies returns the background, foreground and spot colours and the size
+// by size grid of colour indices ethereum-blockies generates for address.
+func blockies(address string, size int) (palette color.Palette, grid [][]uint8) {
+	r := newBlockiesRand(strings.ToLower(address))
+	fg, bg, spot := r.Color(), r.Color(), r.Color()
+	palette = color.Palette{bg, fg, spot}
+
+	dataWidth := (size + 1) / 2
+	for y := 0; y < size; y++ {
+		row := make([]uint8, size)
+		for x := 0; x < dataWidth; x++ {
+			row[x] = uint8(math.Floor(r.Float64() * 2.3))
+			row[size-1-x] = row[x]
+		}
+		grid = append(grid, row)
+	}
+	return
+}
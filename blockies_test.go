@@ -0,0 +1,60 @@
+package main
+
+import (
+	"image/color"
+	"testing"
+)
+
+// TestBlockies checks blockies against the colours and pattern
+// ethereum-blockies generates for the same seeds.
+func TestBlockies(t *testing.T) {
+	tests := []struct {
+		address string
+		size    int
+		colors  [3]string // background, foreground and spot
+		rows    []string
+	}{
+		{
+			"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", 8,
+			[3]string{
+				"hsl(160,99.25892420113087%,52.513964427635074%)",
+				"hsl(222,80.46593819744885%,37.79142468702048%)",
+				"hsl(210,40.28361354023218%,42.68841225421056%)",
+			},
+			[]string{"22111122", "01022010", "01100110", "00111100", "00000000", "01211210", "00122100", "10100101"},
+		},
+		{
+			"1BoatSLRHtKNngkdXEeobR76b53LETtpyT", 7,
+			[3]string{
+				"hsl(87,89.44587130099535%,70.7306079682894%)",
+				"hsl(93,81.39578035101295%,48.62779853865504%)",
+				"hsl(100,66.89117013476789%,33.1417097360827%)",
+			},
+			[]string{"1000001", "0110110", "0110110", "0111110", "1110111", "1000001", "0001000"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			palette, grid := blockies(tt.address, tt.size)
+			for i, s := range tt.colors {
+				want, err := parseColor(s)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := color.NRGBAModel.Convert(palette[i]); got != want {
+					t.Errorf("palette[%d] = %v, want %v from %s", i, got, want, s)
+				}
+			}
+			if len(grid) != tt.size {
+				t.Fatalf("%d rows, want %d", len(grid), tt.size)
+			}
+			for y, row := range grid {
+				for x, got := range row {
+					if want := tt.rows[y][x] - '0'; got != want {
+						t.Fatalf("cell %d,%d = %d, want %d", x, y, got, want)
+					}
+				}
+			}
+		})
+	}
+}
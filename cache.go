@@ -0,0 +1,485 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache stores rendered cards by the key from cardCacheKey. Put keeps an entry
+// for ttl, or until it is evicted when ttl is 0. Invalidate removes every card
+// of an id, or every card when id is empty.
+type Cache interface {
+	Get(key string) (data []byte, ok bool)
+	Put(key string, data []byte, ttl time.Duration) error
+	Delete(key string) error
+	Invalidate(id string) error
+}
+
+// sizedCache is a Cache that knows how many bytes it holds. memcached does not
+// say, so cards kept there do not count towards the cache-bytes stat.
+type sizedCache interface {
+	Size() int64
+}
+
+// cacheSize returns the bytes cardCache holds, or 0 without a cache that can
+// tell.
+func cacheSize() int64 {
+	if c, ok := cardCache.(sizedCache); ok {
+		return c.Size()
+	}
+	return 0
+}
+
+const diskCacheTempPrefix = ".tmp-"
+
+// diskCacheExpiryMagic starts disk cache entries that expire, followed by the
+// expiry in unix nanoseconds, so that entries without a TTL are stored as is.
+const diskCacheExpiryMagic = "persona-expires:"
+
+// diskCache keeps rendered cards in a directory, evicting the least recently
+// used entries once they take more than maxBytes. It only ever touches files
+// named like cache keys, so other files in the directory are left alone.
+type diskCache struct {
+	dir      string
+	maxBytes int64
+
+	mu      sync.Mutex
+	size    int64
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+type diskCacheEntry struct {
+	key  string
+	size int64
+}
+
+func newDiskCache(dir string, maxBytes int64) (d *diskCache, err error) {
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return
+	}
+	d = &diskCache{dir: dir, maxBytes: maxBytes, lru: list.New(), entries: map[string]*list.Element{}}
+	var infos []os.FileInfo
+	if infos, err = d.readDir(); err != nil {
+		return
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ModTime().After(infos[j].ModTime())
+	})
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, info := range infos {
+		d.entries[info.Name()] = d.lru.PushBack(&diskCacheEntry{key: info.Name(), size: info.Size()})
+		d.size += info.Size()
+	}
+	err = d.evict()
+	return
+}
+
+// readDir lists the cache entries in the directory.
+func (d *diskCache) readDir() (entries []os.FileInfo, err error) {
+	var infos []os.FileInfo
+	if infos, err = ioutil.ReadDir(d.dir); err != nil {
+		return
+	}
+	for _, info := range infos {
+		if info.Mode().IsRegular() && isCardCacheKey(info.Name()) {
+			entries = append(entries, info)
+		}
+	}
+	return
+}
+
+func (d *diskCache) Get(key string) (data []byte, ok bool) {
+	data, _, ok = d.GetExpires(key)
+	return
+}
+
+func (d *diskCache) GetExpires(key string) (data []byte, expires time.Time, ok bool) {
+	name := filepath.Join(d.dir, key)
+	var err error
+	if data, err = ioutil.ReadFile(name); err != nil {
+		return
+	}
+	now := time.Now()
+	if n := len(diskCacheExpiryMagic) + 8; len(data) >= n && string(data[:len(diskCacheExpiryMagic)]) == diskCacheExpiryMagic {
+		if expires = time.Unix(0, int64(binary.BigEndian.Uint64(data[len(diskCacheExpiryMagic):n]))); !now.Before(expires) {
+			_ = d.Delete(key)
+			return nil, time.Time{}, false
+		}
+		data = data[n:]
+	}
+	_ = os.Chtimes(name, now, now)
+	d.mu.Lock()
+	if e, ok := d.entries[key]; ok {
+		d.lru.MoveToFront(e)
+	}
+	d.mu.Unlock()
+	ok = true
+	return
+}
+
+func (d *diskCache) Put(key string, data []byte, ttl time.Duration) (err error) {
+	var f *os.File
+	if f, err = ioutil.TempFile(d.dir, diskCacheTempPrefix); err != nil {
+		return
+	}
+	if ttl > 0 {
+		header := make([]byte, len(diskCacheExpiryMagic)+8)
+		copy(header, diskCacheExpiryMagic)
+		binary.BigEndian.PutUint64(header[len(diskCacheExpiryMagic):], uint64(time.Now().Add(ttl).UnixNano()))
+		data = append(header, data...)
+	}
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return
+	}
+	if err = f.Close(); err != nil {
+		os.Remove(f.Name())
+		return
+	}
+	if err = os.Rename(f.Name(), filepath.Join(d.dir, key)); err != nil {
+		os.Remove(f.Name())
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.forget(key)
+	d.entries[key] = d.lru.PushFront(&diskCacheEntry{key: key, size: int64(len(data))})
+	d.size += int64(len(data))
+	return d.evict()
+}
+
+func (d *diskCache) Size() int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.size
+}
+
+func (d *diskCache) Delete(key string) (err error) {
+	if err = os.Remove(filepath.Join(d.dir, key)); os.IsNotExist(err) {
+		err = nil
+	}
+	d.mu.Lock()
+	d.forget(key)
+	d.mu.Unlock()
+	return
+}
+
+func (d *diskCache) Invalidate(id string) (err error) {
+	var infos []os.FileInfo
+	if infos, err = d.readDir(); err != nil {
+		return
+	}
+	for _, info := range infos {
+		if id != "" && !strings.HasPrefix(info.Name(), id+cardCacheKeySeparator) {
+			continue
+		}
+		if err = d.Delete(info.Name()); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// evict removes the least recently used entries until the cache fits in
+// maxBytes. The caller holds d.mu.
+func (d *diskCache) evict() (err error) {
+	for d.size > d.maxBytes && d.lru.Len() > 0 {
+		key := d.lru.Back().Value.(*diskCacheEntry).key
+		if err = os.Remove(filepath.Join(d.dir, key)); err != nil && !os.IsNotExist(err) {
+			return
+		}
+		err = nil
+		d.forget(key)
+		addStats(renderStats{CacheEvictions: 1})
+	}
+	return
+}
+
+// forget drops key from the index. The caller holds d.mu.
+func (d *diskCache) forget(key string) {
+	if e, ok := d.entries[key]; ok {
+		d.lru.Remove(e)
+		delete(d.entries, key)
+		d.size -= e.Value.(*diskCacheEntry).size
+	}
+}
+
+type memoryCacheEntry struct {
+	key     string
+	data    []byte
+	expires time.Time
+}
+
+// memoryCache keeps rendered cards in memory, evicting the least recently
+// used entries once they take more than maxBytes.
+type memoryCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	size     int64
+	lru      *list.List
+	entries  map[string]*list.Element
+}
+
+func newMemoryCache(maxBytes int64) *memoryCache {
+	return &memoryCache{maxBytes: maxBytes, lru: list.New(), entries: map[string]*list.Element{}}
+}
+
+func (m *memoryCache) Get(key string) (data []byte, ok bool) {
+	data, _, ok = m.GetExpires(key)
+	return
+}
+
+func (m *memoryCache) GetExpires(key string) (data []byte, expires time.Time, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var e *list.Element
+	if e, ok = m.entries[key]; !ok {
+		return
+	}
+	entry := e.Value.(*memoryCacheEntry)
+	if !entry.expires.IsZero() && !time.Now().Before(entry.expires) {
+		m.remove(key)
+		return nil, time.Time{}, false
+	}
+	m.lru.MoveToFront(e)
+	return entry.data, entry.expires, true
+}
+
+func (m *memoryCache) Put(key string, data []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.remove(key)
+	if int64(len(data)) > m.maxBytes {
+		return nil
+	}
+	entry := &memoryCacheEntry{key: key, data: data}
+	if ttl > 0 {
+		entry.expires = time.Now().Add(ttl)
+	}
+	m.entries[key] = m.lru.PushFront(entry)
+	m.size += int64(len(data))
+	for m.size > m.maxBytes {
+		m.remove(m.lru.Back().Value.(*memoryCacheEntry).key)
+		addStats(renderStats{CacheEvictions: 1})
+	}
+	return nil
+}
+
+func (m *memoryCache) Size() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.size
+}
+
+func (m *memoryCache) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.remove(key)
+	return nil
+}
+
+func (m *memoryCache) Invalidate(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key := range m.entries {
+		if id == "" || strings.HasPrefix(key, id+cardCacheKeySeparator) {
+			m.remove(key)
+		}
+	}
+	return nil
+}
+
+func (m *memoryCache) remove(key string) {
+	if e, ok := m.entries[key]; ok {
+		m.lru.Remove(e)
+		delete(m.entries, key)
+		m.size -= int64(len(e.Value.(*memoryCacheEntry).data))
+	}
+}
+
+// expiringCache is a Cache that can tell when an entry expires. GetExpires
+// returns the zero time for entries kept until they are evicted.
+type expiringCache interface {
+	GetExpires(key string) (data []byte, expires time.Time, ok bool)
+}
+
+// tieredCache looks keys up in each cache in turn, fastest first, and copies
+// hits into the caches before the one that had the card, for the time the
+// card has left. A hit in a cache that cannot tell is only copied without
+// -cache-ttl, as it might be about to expire.
+type tieredCache []Cache
+
+func (t tieredCache) Get(key string) (data []byte, ok bool) {
+	for i, c := range t {
+		var ttl time.Duration
+		if data, ttl, ok = getTTL(c, key); ok {
+			if ttl >= 0 {
+				for _, upper := range t[:i] {
+					_ = upper.Put(key, data, ttl)
+				}
+			}
+			return
+		}
+	}
+	return
+}
+
+// getTTL gets key from c with the time it has left: 0 when it is kept until
+// evicted, and -1 when c cannot tell.
+func getTTL(c Cache, key string) (data []byte, ttl time.Duration, ok bool) {
+	e, ok := c.(expiringCache)
+	if !ok {
+		if data, ok = c.Get(key); ok && optCacheTTL > 0 {
+			ttl = -1
+		}
+		return
+	}
+	var expires time.Time
+	if data, expires, ok = e.GetExpires(key); ok && !expires.IsZero() {
+		if ttl = time.Until(expires); ttl <= 0 {
+			ttl = -1
+		}
+	}
+	return
+}
+
+func (t tieredCache) Size() (size int64) {
+	for _, c := range t {
+		if c, ok := c.(sizedCache); ok {
+			size += c.Size()
+		}
+	}
+	return
+}
+
+func (t tieredCache) Put(key string, data []byte, ttl time.Duration) (err error) {
+	for _, c := range t {
+		if errPut := c.Put(key, data, ttl); err == nil {
+			err = errPut
+		}
+	}
+	return
+}
+
+func (t tieredCache) Delete(key string) (err error) {
+	for _, c := range t {
+		if errDelete := c.Delete(key); err == nil {
+			err = errDelete
+		}
+	}
+	return
+}
+
+func (t tieredCache) Invalidate(id string) (err error) {
+	for _, c := range t {
+		if errInvalidate := c.Invalidate(id); err == nil {
+			err = errInvalidate
+		}
+	}
+	return
+}
+
+// cacheKeyIgnoredFlags do not change how a card looks. -fold-case is already
+// applied to the id and name a key is made from.
+var cacheKeyIgnoredFlags = map[string]bool{
+	"animate":                true,
+	"animation":              true,
+	"cache-dir":              true,
+	"cache-max-bytes":        true,
+	"cache-ttl":              true,
+	"datauri":                true,
+	"duration":               true,
+	"favicon":                true,
+	"fold-case":              true,
+	"formats":                true,
+	"frames":                 true,
+	"memcached":              true,
+	"memory-cache-max-bytes": true,
+	"parallelism":            true,
+	"render-timeout":         true,
+	"stats":                  true,
+}
+
+// renderVersion is part of every cache key. Bump it with changes that alter how
+// cards render for the same options, so caches do not serve stale cards.
+const renderVersion = 7
+
+// cardCacheKeySeparator follows the id that starts every cache key. It is not
+// in idCharset, so the keys of one id never share a prefix with another id.
+const cardCacheKeySeparator = "."
+
+// isCardCacheKey reports whether name is laid out like a key from
+// cardCacheKey: an id, a hex sha256 and a card format, joined by dots.
+func isCardCacheKey(name string) bool {
+	parts := strings.Split(name, cardCacheKeySeparator)
+	if len(parts) != 3 || parts[0] == "" || strings.Trim(parts[0], idCharset) != "" || len(parts[1]) != sha256.Size*2 {
+		return false
+	}
+	if strings.Trim(parts[1], "0123456789abcdef") != "" {
+		return false
+	}
+	_, ok := cardFormats[parts[2]]
+	return ok
+}
+
+func cardCacheKey(id, name, address, format string) (key string, err error) {
+	var logo []byte
+	if logo, err = ioutil.ReadFile(filepath.Join("src", "logos", id+"-logo.png")); err != nil {
+		return
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "v%d %q %q %q %q\n", renderVersion, id, name, address, format)
+	flag.VisitAll(func(f *flag.Flag) {
+		if !cacheKeyIgnoredFlags[f.Name] {
+			fmt.Fprintf(h, "%s=%q\n", f.Name, f.Value.String())
+		}
+	})
+	fmt.Fprintf(h, "%+v\n", layout)
+	h.Write(fontDigest.Sum(nil))
+	h.Write(logo)
+	if watermark != nil {
+		h.Write(watermark.Pix)
+	}
+	key = id + cardCacheKeySeparator + hex.EncodeToString(h.Sum(nil)) + cardCacheKeySeparator + format
+	return
+}
+
+// persistentCache reports whether a cache that outlives the process is
+// configured, as -memory-cache-max-bytes only lasts as long as the process.
+func persistentCache() bool {
+	return optCacheDir != "" || optMemcached != ""
+}
+
+// invalidateSeed removes the cached cards of id from every cache.
+func invalidateSeed(id string) error {
+	if cardCache == nil {
+		return nil
+	}
+	id, _, _ = normalizeItem(id, "", "")
+	if err := validateItem(id, "", ""); err != nil {
+		return err
+	}
+	return cardCache.Invalidate(id)
+}
+
+// invalidateAll removes every cached card from every cache.
+func invalidateAll() error {
+	if cardCache == nil {
+		return nil
+	}
+	return cardCache.Invalidate("")
+}
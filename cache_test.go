@@ -0,0 +1,308 @@
+package main
+
+import (
+	"fmt"
+	"github.com/tdewolff/canvas"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testCacheKey(id string, n int) string {
+	return fmt.Sprintf("%s.%064x.png", id, n)
+}
+
+func TestIsCardCacheKey(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{testCacheKey("bitcoin-btc", 1), true},
+		{testCacheKey("usd_coin", 2), true},
+		{fmt.Sprintf("bitcoin-btc.%064x.svg", 3), true},
+		{fmt.Sprintf("bitcoin-btc.%064x.gif", 3), false},
+		{fmt.Sprintf("bitcoin-btc.%063x.png", 3), false},
+		{fmt.Sprintf("Bitcoin.%064x.png", 3), false},
+		{fmt.Sprintf(".%064x.png", 3), false},
+		{"bitcoin-btc." + string(make([]byte, 64)) + ".png", false},
+		{"notes.txt", false},
+		{diskCacheTempPrefix + "123", false},
+	}
+	for _, tt := range tests {
+		if got := isCardCacheKey(tt.name); got != tt.want {
+			t.Errorf("isCardCacheKey(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// testCaches runs the behaviour every Cache shares against a fresh cache
+// holding up to maxBytes.
+func testCaches(t *testing.T, newCache func(t *testing.T, maxBytes int64) Cache) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, c Cache)
+	}{
+		{"put and get", func(t *testing.T, c Cache) {
+			if err := c.Put(testCacheKey("a", 1), []byte("card"), 0); err != nil {
+				t.Fatal(err)
+			}
+			if data, ok := c.Get(testCacheKey("a", 1)); !ok || string(data) != "card" {
+				t.Errorf("Get = %q, %v, want card, true", data, ok)
+			}
+			if _, ok := c.Get(testCacheKey("a", 2)); ok {
+				t.Error("Get of a missing key hit")
+			}
+		}},
+		{"ttl", func(t *testing.T, c Cache) {
+			if err := c.Put(testCacheKey("a", 1), []byte("card"), time.Nanosecond); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Put(testCacheKey("a", 2), []byte("card"), time.Hour); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(time.Millisecond)
+			if _, ok := c.Get(testCacheKey("a", 1)); ok {
+				t.Error("Get of an expired key hit")
+			}
+			if data, ok := c.Get(testCacheKey("a", 2)); !ok || string(data) != "card" {
+				t.Errorf("Get = %q, %v, want card, true", data, ok)
+			}
+		}},
+		{"evicts least recently used", func(t *testing.T, c Cache) {
+			for i := 1; i <= 3; i++ {
+				if err := c.Put(testCacheKey("a", i), []byte("0123456789"), 0); err != nil {
+					t.Fatal(err)
+				}
+			}
+			c.Get(testCacheKey("a", 1))
+			if err := c.Put(testCacheKey("a", 4), []byte("0123456789"), 0); err != nil {
+				t.Fatal(err)
+			}
+			for i, want := range []bool{true, false, true, true} {
+				if _, ok := c.Get(testCacheKey("a", i+1)); ok != want {
+					t.Errorf("Get of entry %d = %v, want %v", i+1, ok, want)
+				}
+			}
+		}},
+		{"invalidate", func(t *testing.T, c Cache) {
+			for _, key := range []string{testCacheKey("a", 1), testCacheKey("a", 2), testCacheKey("ab", 1)} {
+				if err := c.Put(key, []byte("card"), 0); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := c.Invalidate("a"); err != nil {
+				t.Fatal(err)
+			}
+			for key, want := range map[string]bool{testCacheKey("a", 1): false, testCacheKey("a", 2): false, testCacheKey("ab", 1): true} {
+				if _, ok := c.Get(key); ok != want {
+					t.Errorf("Get(%q) after Invalidate(a) = %v, want %v", key, ok, want)
+				}
+			}
+			if err := c.Invalidate(""); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := c.Get(testCacheKey("ab", 1)); ok {
+				t.Error("Get after Invalidate of everything hit")
+			}
+		}},
+		{"size", func(t *testing.T, c Cache) {
+			size := func() int64 { return c.(sizedCache).Size() }
+			for i := 1; i <= 2; i++ {
+				if err := c.Put(testCacheKey("a", i), []byte("card"), 0); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := c.Put(testCacheKey("a", 1), []byte("cards"), 0); err != nil {
+				t.Fatal(err)
+			}
+			if got := size(); got != 9 {
+				t.Errorf("Size after writing 13 bytes to 2 cards = %d, want 9", got)
+			}
+			if err := c.Delete(testCacheKey("a", 1)); err != nil {
+				t.Fatal(err)
+			}
+			if got := size(); got != 4 {
+				t.Errorf("Size after Delete = %d, want 4", got)
+			}
+		}},
+		{"delete", func(t *testing.T, c Cache) {
+			if err := c.Put(testCacheKey("a", 1), []byte("card"), 0); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Delete(testCacheKey("a", 1)); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Delete(testCacheKey("a", 1)); err != nil {
+				t.Errorf("Delete of a missing key: %v", err)
+			}
+			if _, ok := c.Get(testCacheKey("a", 1)); ok {
+				t.Error("Get of a deleted key hit")
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, newCache(t, 35))
+		})
+	}
+}
+
+func TestMemoryCache(t *testing.T) {
+	testCaches(t, func(t *testing.T, maxBytes int64) Cache {
+		return newMemoryCache(maxBytes)
+	})
+}
+
+func TestDiskCache(t *testing.T) {
+	testCaches(t, func(t *testing.T, maxBytes int64) Cache {
+		d, err := newDiskCache(t.TempDir(), maxBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	})
+}
+
+func TestDiskCacheLeavesOtherFiles(t *testing.T) {
+	dir := t.TempDir()
+	others := []string{"notes.txt", diskCacheTempPrefix + "123", testCacheKey("a", 1) + ".bak"}
+	for _, name := range others {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), make([]byte, 100), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-time.Hour)
+	for i := 1; i <= 2; i++ {
+		name := filepath.Join(dir, testCacheKey("a", i))
+		if err := ioutil.WriteFile(name, []byte("0123456789"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(name, old, old.Add(time.Duration(i)*time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d, err := newDiskCache(dir, 25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.size != 20 {
+		t.Errorf("size of existing entries = %d, want 20", d.size)
+	}
+	if err = d.Put(testCacheKey("b", 1), []byte("0123456789"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err = d.Invalidate("b"); err != nil {
+		t.Fatal(err)
+	}
+	if err = d.Invalidate(""); err != nil {
+		t.Fatal(err)
+	}
+	if d.size != 0 {
+		t.Errorf("size after invalidating everything = %d, want 0", d.size)
+	}
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	sort.Strings(others)
+	if fmt.Sprint(names) != fmt.Sprint(others) {
+		t.Errorf("files left = %q, want %q", names, others)
+	}
+}
+
+func TestTieredCache(t *testing.T) {
+	fast, slow := newMemoryCache(1<<10), newMemoryCache(1<<10)
+	c := tieredCache{fast, slow}
+	if err := slow.Put(testCacheKey("a", 1), []byte("card"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if data, ok := c.Get(testCacheKey("a", 1)); !ok || string(data) != "card" {
+		t.Fatalf("Get = %q, %v, want card, true", data, ok)
+	}
+	if _, ok := fast.Get(testCacheKey("a", 1)); !ok {
+		t.Error("hit in the slow cache was not copied into the fast one")
+	}
+	if err := c.Invalidate("a"); err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range []*memoryCache{fast, slow} {
+		if _, ok := m.Get(testCacheKey("a", 1)); ok {
+			t.Errorf("cache %d still has the card after Invalidate", i)
+		}
+	}
+}
+
+// TestRenderCardStats checks that concurrent renders of a card count one miss
+// between them, and that cache-bytes is what the cache holds.
+func TestRenderCardStats(t *testing.T) {
+	setupTestOptions(t)
+	defer func(c Cache) { cardCache = c }(cardCache)
+	cache := newMemoryCache(1 << 20)
+	cardCache = cache
+	before := statsSnapshot()
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var c *canvas.Canvas
+			if err := renderCard(ioutil.Discard, &c, "bitcoin-btc", "Bitcoin", "1BoatSLRHtKNngkdXEeobR76b53LETtpyT", "svg"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	s := statsSnapshot().sub(before)
+	if s.CacheMisses != 1 {
+		t.Errorf("cache-misses = %d, want 1", s.CacheMisses)
+	}
+	if want := cache.Size(); want == 0 || s.CacheBytes != want {
+		t.Errorf("cache-bytes = %d, want %d", s.CacheBytes, want)
+	}
+	if err := cache.Invalidate(""); err != nil {
+		t.Fatal(err)
+	}
+	if s := statsSnapshot(); s.CacheBytes != 0 {
+		t.Errorf("cache-bytes after Invalidate = %d, want 0", s.CacheBytes)
+	}
+}
+
+// TestTieredCacheKeepsExpiry checks that a card copied into a faster cache
+// expires when it would have in the slower one, not a whole TTL later.
+func TestTieredCacheKeepsExpiry(t *testing.T) {
+	const ttl = 200 * time.Millisecond
+	defer func(ttl time.Duration) { optCacheTTL = ttl }(optCacheTTL)
+	optCacheTTL = ttl
+	disk, err := newDiskCache(t.TempDir(), 1<<10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, slow := range []Cache{newMemoryCache(1 << 10), disk} {
+		fast := newMemoryCache(1 << 10)
+		c := tieredCache{fast, slow}
+		if err := slow.Put(testCacheKey("a", 1), []byte("card"), ttl); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(ttl * 3 / 5)
+		if _, ok := c.Get(testCacheKey("a", 1)); !ok {
+			t.Fatal("Get of an aged card missed")
+		}
+		if _, ok := fast.Get(testCacheKey("a", 1)); !ok {
+			t.Fatal("hit in the slow cache was not copied into the fast one")
+		}
+		time.Sleep(ttl * 3 / 5)
+		if _, ok := fast.Get(testCacheKey("a", 1)); ok {
+			t.Errorf("%T: copy outlived the card it was copied from", slow)
+		}
+	}
+}
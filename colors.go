@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"sort"
+)
+
+// hsl converts to RGB. Products are wrapped in float64 conversions, which stop
+// the compiler from fusing them into FMA instructions on arm64 or GOAMD64=v3,
+// so that every platform picks the same colours.
+func hsl(h, s, l float64) color.RGBA {
+	h = math.Mod(math.Mod(h, 360)+360, 360) / 360
+	hue := func(t float64) float64 {
+		t = math.Mod(t+1, 1)
+		q := l + s - float64(l*s)
+		if l < 0.5 {
+			q = l * (1 + s)
+		}
+		p := float64(2*l) - q
+		switch {
+		case t < 1.0/6.0:
+			return p + float64((q-p)*6*t)
+		case t < 1.0/2.0:
+			return q
+		case t < 2.0/3.0:
+			return p + float64((q-p)*(2.0/3.0-t)*6)
+		}
+		return p
+	}
+	return color.RGBA{
+		R: uint8(math.Round(hue(h+1.0/3.0) * 255)),
+		G: uint8(math.Round(hue(h) * 255)),
+		B: uint8(math.Round(hue(h-1.0/3.0) * 255)),
+		A: 255,
+	}
+}
+
+// toHSL converts c to hue in degrees and saturation and lightness in 0 to 1.
+func toHSL(c color.Color) (h, s, l float64) {
+	r16, g16, b16, _ := c.RGBA()
+	r, g, b := float64(r16)/0xffff, float64(g16)/0xffff, float64(b16)/0xffff
+	max, min := math.Max(r, math.Max(g, b)), math.Min(r, math.Min(g, b))
+	l = (max + min) / 2
+	if max == min {
+		return
+	}
+	d := max - min
+	s = d / (1 - math.Abs(float64(2*l)-1))
+	switch max {
+	case r:
+		h = math.Mod((g-b)/d+6, 6)
+	case g:
+		h = (b-r)/d + 2
+	default:
+		h = (r-g)/d + 4
+	}
+	h *= 60
+	return
+}
+
+func clamp01(v float64) float64 {
+	return math.Max(0, math.Min(1, v))
+}
+
+func lighten(c color.Color, amount float64) color.RGBA {
+	h, s, l := toHSL(c)
+	return hsl(h, s, clamp01(l+amount))
+}
+
+func darken(c color.Color, amount float64) color.RGBA {
+	return lighten(c, -amount)
+}
+
+func rotateHue(c color.Color, degrees float64) color.RGBA {
+	h, s, l := toHSL(c)
+	return hsl(h+degrees, s, l)
+}
+
+func srgbToLinear(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(v float64) float64 {
+	if v <= 0.0031308 {
+		return 12.92 * v
+	}
+	return float64(1.055*math.Pow(v, 1/2.4)) - 0.055
+}
+
+// oklch converts from OKLCH, with lightness in 0 to 1, chroma roughly in 0 to
+// 0.4 and hue in degrees. Colours outside of sRGB are clipped per channel.
+// Products are wrapped in float64 conversions as in hsl.
+func oklch(l, c, h float64) color.RGBA {
+	sin, cos := math.Sincos(h * math.Pi / 180)
+	a, b := c*cos, c*sin
+
+	l_ := l + float64(0.3963377774*a) + float64(0.2158037573*b)
+	m_ := l - float64(0.1055613458*a) - float64(0.0638541728*b)
+	s_ := l - float64(0.0894841775*a) - float64(1.2914855480*b)
+	l3, m3, s3 := l_*l_*l_, m_*m_*m_, s_*s_*s_
+
+	channel := func(v float64) uint8 {
+		return uint8(math.Round(clamp01(linearToSRGB(v)) * 255))
+	}
+	return color.RGBA{
+		R: channel(float64(4.0767416621*l3) - float64(3.3077115913*m3) + float64(0.2309699292*s3)),
+		G: channel(-float64(1.2684380046*l3) + float64(2.6097574011*m3) - float64(0.3413193965*s3)),
+		B: channel(-float64(0.0041960863*l3) - float64(0.7034186147*m3) + float64(1.7076147010*s3)),
+		A: 255,
+	}
+}
+
+// toOKLCH converts c to OKLCH, see oklch.
+func toOKLCH(col color.Color) (l, c, h float64) {
+	r16, g16, b16, _ := col.RGBA()
+	r, g, b := srgbToLinear(float64(r16)/0xffff), srgbToLinear(float64(g16)/0xffff), srgbToLinear(float64(b16)/0xffff)
+
+	l_ := math.Cbrt(float64(0.4122214708*r) + float64(0.5363033147*g) + float64(0.0514459929*b))
+	m_ := math.Cbrt(float64(0.2119034982*r) + float64(0.6806995451*g) + float64(0.1073969566*b))
+	s_ := math.Cbrt(float64(0.0883024619*r) + float64(0.2817188376*g) + float64(0.6299787005*b))
+
+	l = float64(0.2104542553*l_) + float64(0.7936177850*m_) - float64(0.0040720468*s_)
+	a := float64(1.9779984951*l_) - float64(2.4285922050*m_) + float64(0.4505937099*s_)
+	bb := float64(0.0259040371*l_) + float64(0.7827717662*m_) - float64(0.8086757660*s_)
+	c = math.Hypot(a, bb)
+	h = math.Mod(float64(math.Atan2(bb, a)*180/math.Pi)+360, 360)
+	return
+}
+
+// Palette turns a hue derived from the address into a colour, keeping the
+// other components fixed.
+type Palette interface {
+	Color(hue float64) color.RGBA
+}
+
+type HSLPalette struct {
+	Saturation, Lightness float64
+}
+
+func (p HSLPalette) Color(hue float64) color.RGBA {
+	return hsl(hue, p.Saturation, p.Lightness)
+}
+
+// OKLCHPalette keeps perceived lightness and chroma equal across hues, unlike
+// HSLPalette where yellows look much lighter than blues.
+type OKLCHPalette struct {
+	Lightness, Chroma float64
+}
+
+func (p OKLCHPalette) Color(hue float64) color.RGBA {
+	return oklch(p.Lightness, p.Chroma, hue)
+}
+
+var palettes = map[string]Palette{}
+
+func registerPalette(name string, palette Palette) {
+	if _, ok := palettes[name]; ok {
+		panic("palette already registered: " + name)
+	}
+	palettes[name] = palette
+}
+
+// lookupPalette returns the palette registered as name or, when name is a CSS
+// color, an OKLCHPalette with its lightness and chroma, so that rings take
+// their hues from the address in the tone of a brand colour.
+func lookupPalette(name string) (Palette, error) {
+	if p, ok := palettes[name]; ok {
+		return p, nil
+	}
+	c, err := parseColor(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown palette: %s", name)
+	}
+	c.A = 255
+	l, chroma, _ := toOKLCH(c)
+	return OKLCHPalette{Lightness: l, Chroma: chroma}, nil
+}
+
+func paletteNames() (names []string) {
+	for name := range palettes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+func init() {
+	registerPalette("vivid", HSLPalette{Saturation: 0.65, Lightness: 0.55})
+	registerPalette("pastel", HSLPalette{Saturation: 0.6, Lightness: 0.78})
+	registerPalette("muted", HSLPalette{Saturation: 0.25, Lightness: 0.5})
+	registerPalette("dark", HSLPalette{Saturation: 0.5, Lightness: 0.3})
+	registerPalette("even", OKLCHPalette{Lightness: 0.68, Chroma: 0.14})
+}
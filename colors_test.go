@@ -0,0 +1,76 @@
+package main
+
+import (
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestColorHelpers(t *testing.T) {
+	red := color.RGBA{R: 255, A: 255}
+	tests := []struct {
+		name      string
+		got, want color.RGBA
+	}{
+		{"lighten", lighten(red, 0.25), color.RGBA{R: 255, G: 128, B: 128, A: 255}},
+		{"darken", darken(red, 0.25), color.RGBA{R: 128, A: 255}},
+		{"lighten clamps", lighten(red, 1), color.RGBA{R: 255, G: 255, B: 255, A: 255}},
+		{"rotate hue", rotateHue(red, 120), color.RGBA{G: 255, A: 255}},
+		{"rotate hue back", rotateHue(red, -120), color.RGBA{B: 255, A: 255}},
+		{"rotate grey", rotateHue(color.RGBA{R: 90, G: 90, B: 90, A: 255}, 45), color.RGBA{R: 90, G: 90, B: 90, A: 255}},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestToHSL(t *testing.T) {
+	for _, c := range []struct{ h, s, l float64 }{
+		{0, 1, 0.5}, {210, 0.6, 0.4}, {95, 0.3, 0.8}, {330, 0.9, 0.2},
+	} {
+		h, s, l := toHSL(hsl(c.h, c.s, c.l))
+		if math.Abs(h-c.h) > 1 || math.Abs(s-c.s) > 0.01 || math.Abs(l-c.l) > 0.01 {
+			t.Errorf("toHSL(hsl(%v, %v, %v)) = %v, %v, %v", c.h, c.s, c.l, h, s, l)
+		}
+	}
+}
+
+func TestToOKLCH(t *testing.T) {
+	// Reference values from the OKLab definition.
+	l, c, h := toOKLCH(color.RGBA{R: 255, A: 255})
+	if math.Abs(l-0.62796) > 1e-4 || math.Abs(c-0.25768) > 1e-4 || math.Abs(h-29.234) > 1e-2 {
+		t.Errorf("toOKLCH(red) = %v, %v, %v, want 0.62796, 0.25768, 29.234", l, c, h)
+	}
+	if l, c, _ := toOKLCH(color.White); math.Abs(l-1) > 1e-4 || c > 1e-4 {
+		t.Errorf("toOKLCH(white) = %v, %v, want 1, 0", l, c)
+	}
+	for _, want := range []color.RGBA{{R: 30, G: 144, B: 255, A: 255}, {R: 200, G: 120, B: 10, A: 255}, {R: 17, G: 17, B: 17, A: 255}} {
+		if got := oklch(toOKLCH(want)); got != want {
+			t.Errorf("oklch(toOKLCH(%v)) = %v", want, got)
+		}
+	}
+	for _, v := range []float64{0, 0.002, 0.04, 0.5, 1} {
+		if got := srgbToLinear(linearToSRGB(v)); math.Abs(got-v) > 1e-9 {
+			t.Errorf("srgbToLinear(linearToSRGB(%v)) = %v", v, got)
+		}
+	}
+}
+
+func TestLookupPalette(t *testing.T) {
+	if p, err := lookupPalette("even"); err != nil || p != palettes["even"] {
+		t.Errorf("lookupPalette(even) = %v, %v, want the registered palette", p, err)
+	}
+	p, err := lookupPalette("#1e90ff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := color.RGBA{R: 30, G: 144, B: 255, A: 255}
+	if _, _, h := toOKLCH(want); p.Color(h) != want {
+		t.Errorf("palette of %v gives %v at its own hue", want, p.Color(h))
+	}
+	if _, err := lookupPalette("neon"); err == nil {
+		t.Error("lookupPalette(neon) did not fail")
+	}
+}
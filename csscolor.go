@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var cssNamedColors = map[string]uint32{
+	"aliceblue": 0xf0f8ff, "antiquewhite": 0xfaebd7, "aqua": 0x00ffff, "aquamarine": 0x7fffd4,
+	"azure": 0xf0ffff, "beige": 0xf5f5dc, "bisque": 0xffe4c4, "black": 0x000000,
+	"blanchedalmond": 0xffebcd, "blue": 0x0000ff, "blueviolet": 0x8a2be2, "brown": 0xa52a2a,
+	"burlywood": 0xdeb887, "cadetblue": 0x5f9ea0, "chartreuse": 0x7fff00, "chocolate": 0xd2691e,
+	"coral": 0xff7f50, "cornflowerblue": 0x6495ed, "cornsilk": 0xfff8dc, "crimson": 0xdc143c,
+	"cyan": 0x00ffff, "darkblue": 0x00008b, "darkcyan": 0x008b8b, "darkgoldenrod": 0xb8860b,
+	"darkgray": 0xa9a9a9, "darkgreen": 0x006400, "darkgrey": 0xa9a9a9, "darkkhaki": 0xbdb76b,
+	"darkmagenta": 0x8b008b, "darkolivegreen": 0x556b2f, "darkorange": 0xff8c00, "darkorchid": 0x9932cc,
+	"darkred": 0x8b0000, "darksalmon": 0xe9967a, "darkseagreen": 0x8fbc8f, "darkslateblue": 0x483d8b,
+	"darkslategray": 0x2f4f4f, "darkslategrey": 0x2f4f4f, "darkturquoise": 0x00ced1, "darkviolet": 0x9400d3,
+	"deeppink": 0xff1493, "deepskyblue": 0x00bfff, "dimgray": 0x696969, "dimgrey": 0x696969,
+	"dodgerblue": 0x1e90ff, "firebrick": 0xb22222, "floralwhite": 0xfffaf0, "forestgreen": 0x228b22,
+	"fuchsia": 0xff00ff, "gainsboro": 0xdcdcdc, "ghostwhite": 0xf8f8ff, "gold": 0xffd700,
+	"goldenrod": 0xdaa520, "gray": 0x808080, "green": 0x008000, "greenyellow": 0xadff2f,
+	"grey": 0x808080, "honeydew": 0xf0fff0, "hotpink": 0xff69b4, "indianred": 0xcd5c5c,
+	"indigo": 0x4b0082, "ivory": 0xfffff0, "khaki": 0xf0e68c, "lavender": 0xe6e6fa,
+	"lavenderblush": 0xfff0f5, "lawngreen": 0x7cfc00, "lemonchiffon": 0xfffacd, "lightblue": 0xadd8e6,
+	"lightcoral": 0xf08080, "lightcyan": 0xe0ffff, "lightgoldenrodyellow": 0xfafad2, "lightgray": 0xd3d3d3,
+	"lightgreen": 0x90ee90, "lightgrey": 0xd3d3d3, "lightpink": 0xffb6c1, "lightsalmon": 0xffa07a,
+	"lightseagreen": 0x20b2aa, "lightskyblue": 0x87cefa, "lightslategray": 0x778899, "lightslategrey": 0x778899,
+	"lightsteelblue": 0xb0c4de, "lightyellow": 0xffffe0, "lime": 0x00ff00, "limegreen": 0x32cd32,
+	"linen": 0xfaf0e6, "magenta": 0xff00ff, "maroon": 0x800000, "mediumaquamarine": 0x66cdaa,
+	"mediumblue": 0x0000cd, "mediumorchid": 0xba55d3, "mediumpurple": 0x9370db, "mediumseagreen": 0x3cb371,
+	"mediumslateblue": 0x7b68ee, "mediumspringgreen": 0x00fa9a, "mediumturquoise": 0x48d1cc, "mediumvioletred": 0xc71585,
+	"midnightblue": 0x191970, "mintcream": 0xf5fffa, "mistyrose": 0xffe4e1, "moccasin": 0xffe4b5,
+	"navajowhite": 0xffdead, "navy": 0x000080, "oldlace": 0xfdf5e6, "olive": 0x808000,
+	"olivedrab": 0x6b8e23, "orange": 0xffa500, "orangered": 0xff4500, "orchid": 0xda70d6,
+	"palegoldenrod": 0xeee8aa, "palegreen": 0x98fb98, "paleturquoise": 0xafeeee, "palevioletred": 0xdb7093,
+	"papayawhip": 0xffefd5, "peachpuff": 0xffdab9, "peru": 0xcd853f, "pink": 0xffc0cb,
+	"plum": 0xdda0dd, "powderblue": 0xb0e0e6, "purple": 0x800080, "rebeccapurple": 0x663399,
+	"red": 0xff0000, "rosybrown": 0xbc8f8f, "royalblue": 0x4169e1, "saddlebrown": 0x8b4513,
+	"salmon": 0xfa8072, "sandybrown": 0xf4a460, "seagreen": 0x2e8b57, "seashell": 0xfff5ee,
+	"sienna": 0xa0522d, "silver": 0xc0c0c0, "skyblue": 0x87ceeb, "slateblue": 0x6a5acd,
+	"slategray": 0x708090, "slategrey": 0x708090, "snow": 0xfffafa, "springgreen": 0x00ff7f,
+	"steelblue": 0x4682b4, "tan": 0xd2b48c, "teal": 0x008080, "thistle": 0xd8bfd8,
+	"tomato": 0xff6347, "turquoise": 0x40e0d0, "violet": 0xee82ee, "wheat": 0xf5deb3,
+	"white": 0xffffff, "whitesmoke": 0xf5f5f5, "yellow": 0xffff00, "yellowgreen": 0x9acd32,
+}
+
+// parseColor parses a CSS colour: a hex colour with 3, 4, 6 or 8 digits, a
+// named colour, transparent, or an rgb(), rgba(), hsl() or hsla() function
+// with comma or space separated arguments and hues in any CSS angle unit.
+func parseColor(s string) (c color.NRGBA, err error) {
+	v := strings.ToLower(strings.TrimSpace(s))
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("invalid color %q: %w", s, err)
+		}
+	}()
+
+	if v == "transparent" {
+		return
+	}
+	if rgb, ok := cssNamedColors[v]; ok {
+		return color.NRGBA{R: uint8(rgb >> 16), G: uint8(rgb >> 8), B: uint8(rgb), A: 255}, nil
+	}
+	if strings.HasPrefix(v, "#") {
+		return parseHexColor(v[1:])
+	}
+
+	open, close := strings.IndexByte(v, '('), strings.LastIndexByte(v, ')')
+	if open < 0 || close != len(v)-1 {
+		err = fmt.Errorf("unknown color format")
+		return
+	}
+	fn, args := strings.TrimSpace(v[:open]), v[open+1:close]
+	alpha := "1"
+	if i := strings.IndexByte(args, '/'); i >= 0 {
+		args, alpha = args[:i], strings.TrimSpace(args[i+1:])
+	}
+	parts := strings.FieldsFunc(args, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+	if len(parts) == 4 {
+		alpha, parts = parts[3], parts[:3]
+	}
+	if len(parts) != 3 {
+		err = fmt.Errorf("%s() takes 3 components and an optional alpha", fn)
+		return
+	}
+	var a float64
+	if a, err = parseColorComponent(alpha, 1); err != nil {
+		return
+	}
+
+	switch fn {
+	case "rgb", "rgba":
+		var ch [3]float64
+		for i, part := range parts {
+			if ch[i], err = parseColorComponent(part, 255); err != nil {
+				return
+			}
+		}
+		c = color.NRGBA{R: uint8(math.Round(ch[0])), G: uint8(math.Round(ch[1])), B: uint8(math.Round(ch[2]))}
+	case "hsl", "hsla":
+		var h, sat, l float64
+		if h, err = parseHue(parts[0]); err != nil {
+			return
+		}
+		if sat, err = parseColorComponent(parts[1], 1); err != nil {
+			return
+		}
+		if l, err = parseColorComponent(parts[2], 1); err != nil {
+			return
+		}
+		rgb := hsl(h, sat, l)
+		c = color.NRGBA{R: rgb.R, G: rgb.G, B: rgb.B}
+	default:
+		err = fmt.Errorf("unknown color function %s()", fn)
+		return
+	}
+	c.A = uint8(math.Round(a * 255))
+	return
+}
+
+func parseHexColor(hex string) (c color.NRGBA, err error) {
+	switch len(hex) {
+	case 3, 4:
+		hex = strings.Repeat(hex[:1], 2) + strings.Repeat(hex[1:2], 2) + strings.Repeat(hex[2:3], 2) + strings.Repeat(hex[3:], 2)
+	case 6, 8:
+	default:
+		err = fmt.Errorf("hex colors need 3, 4, 6 or 8 digits")
+		return
+	}
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+	var v uint64
+	if v, err = strconv.ParseUint(hex, 16, 32); err != nil {
+		return
+	}
+	return color.NRGBA{R: uint8(v >> 24), G: uint8(v >> 16), B: uint8(v >> 8), A: uint8(v)}, nil
+}
+
+// parseColorComponent parses a number, or a percentage of max, clamped to 0 to max.
+func parseColorComponent(s string, max float64) (v float64, err error) {
+	if strings.HasSuffix(s, "%") {
+		if v, err = strconv.ParseFloat(s[:len(s)-1], 64); err != nil {
+			return
+		}
+		v = v / 100 * max
+	} else if v, err = strconv.ParseFloat(s, 64); err != nil {
+		return
+	}
+	return math.Max(0, math.Min(max, v)), nil
+}
+
+// cssAngleUnits are the degrees in one of each CSS angle unit.
+var cssAngleUnits = []struct {
+	suffix  string
+	degrees float64
+}{
+	{"deg", 1},
+	{"grad", 0.9},
+	{"rad", 180 / math.Pi},
+	{"turn", 360},
+}
+
+// parseHue parses a hue in degrees, either a bare number or a CSS angle.
+func parseHue(s string) (h float64, err error) {
+	scale := 1.0
+	for _, unit := range cssAngleUnits {
+		if strings.HasSuffix(s, unit.suffix) {
+			s, scale = strings.TrimSuffix(s, unit.suffix), unit.degrees
+			break
+		}
+	}
+	if h, err = strconv.ParseFloat(s, 64); err != nil {
+		return
+	}
+	return h * scale, nil
+}
+
+// colorValue is a flag.Value setting a colour from a CSS colour string.
+type colorValue struct {
+	c *color.RGBA
+}
+
+func (v colorValue) String() string {
+	if v.c == nil {
+		return ""
+	}
+	n := color.NRGBAModel.Convert(*v.c).(color.NRGBA)
+	if n.A == 255 {
+		return fmt.Sprintf("#%02x%02x%02x", n.R, n.G, n.B)
+	}
+	return fmt.Sprintf("#%02x%02x%02x%02x", n.R, n.G, n.B, n.A)
+}
+
+func (v colorValue) Set(s string) error {
+	c, err := parseColor(s)
+	if err != nil {
+		return err
+	}
+	*v.c = color.RGBAModel.Convert(c).(color.RGBA)
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"image/color"
+	"testing"
+)
+
+func TestParseColor(t *testing.T) {
+	tests := []struct {
+		in   string
+		want color.NRGBA
+	}{
+		{"#1e90ff", color.NRGBA{R: 0x1e, G: 0x90, B: 0xff, A: 255}},
+		{"#1E90FF80", color.NRGBA{R: 0x1e, G: 0x90, B: 0xff, A: 0x80}},
+		{"#f0a", color.NRGBA{R: 0xff, G: 0x00, B: 0xaa, A: 255}},
+		{"#f0a8", color.NRGBA{R: 0xff, G: 0x00, B: 0xaa, A: 0x88}},
+		{" DodgerBlue ", color.NRGBA{R: 0x1e, G: 0x90, B: 0xff, A: 255}},
+		{"transparent", color.NRGBA{}},
+		{"rgb(30,144,255)", color.NRGBA{R: 30, G: 144, B: 255, A: 255}},
+		{"rgb(30 144 255 / 50%)", color.NRGBA{R: 30, G: 144, B: 255, A: 128}},
+		{"rgba(100%, 0%, 0%, 0.25)", color.NRGBA{R: 255, A: 64}},
+		{"rgb(300 -5 0)", color.NRGBA{R: 255, A: 255}},
+		{"hsl(0 100% 50%)", color.NRGBA{R: 255, A: 255}},
+		{"hsl(120deg, 100%, 50%)", color.NRGBA{G: 255, A: 255}},
+		{"hsl(0.5turn 100% 50%)", color.NRGBA{G: 255, B: 255, A: 255}},
+		{"hsl(400grad 100% 50%)", color.NRGBA{R: 255, A: 255}},
+		{"hsla(3.14159rad, 100%, 50%, 0)", color.NRGBA{G: 255, B: 255}},
+	}
+	for _, tt := range tests {
+		got, err := parseColor(tt.in)
+		if err != nil {
+			t.Errorf("parseColor(%q): %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseColor(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseColorErrors(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"#12345",
+		"#ggg",
+		"notacolor",
+		"rgb(1, 2)",
+		"rgb(1, 2, 3",
+		"cmyk(0, 0, 0)",
+		"hsl(1turns 100% 50%)",
+		"rgb(a, b, c)",
+	} {
+		if c, err := parseColor(in); err == nil {
+			t.Errorf("parseColor(%q) = %v, want an error", in, c)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"github.com/tdewolff/canvas"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"math/rand"
+)
+
+// boxBlur approximates a gaussian blur with the given standard deviation in
+// pixels by three passes of a box blur in each direction. It returns a new
+// image and uses img as scratch space, so that pooled buffers can go back to
+// the pool while the blurred image is drawn.
+func boxBlur(img *image.RGBA, sigma float64) *image.RGBA {
+	out := image.NewRGBA(img.Bounds())
+	copy(out.Pix, img.Pix)
+	r := int(math.Round((math.Sqrt(float64(4*sigma*sigma)+1) - 1) / 2))
+	if r < 1 {
+		return out
+	}
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	src, dst := out, img
+	for pass := 0; pass < 3; pass++ {
+		boxBlurLine(dst.Pix, src.Pix, w, h, 4, src.Stride, r)
+		src, dst = dst, src
+		boxBlurLine(dst.Pix, src.Pix, h, w, src.Stride, 4, r)
+		src, dst = dst, src
+	}
+	return src
+}
+
+// boxBlurLine blurs n lines of length pixels each, where step is the byte
+// distance between neighbouring pixels of a line and stride between lines.
+func boxBlurLine(dst, src []uint8, length, n, step, stride, r int) {
+	size := 2*r + 1
+	for line := 0; line < n; line++ {
+		base := line * stride
+		for ch := 0; ch < 4; ch++ {
+			sum := 0
+			for i := 0; i < r && i < length; i++ {
+				sum += int(src[base+i*step+ch])
+			}
+			for i := 0; i < length; i++ {
+				if j := i + r; j < length {
+					sum += int(src[base+j*step+ch])
+				}
+				if j := i - r - 1; j >= 0 {
+					sum -= int(src[base+j*step+ch])
+				}
+				dst[base+i*step+ch] = uint8((sum + size/2) / size)
+			}
+		}
+	}
+}
+
+// drawShadow renders the shapes drawn by shape in the shadow colour, blurs
+// them and composites the result onto ctx, offset down and to the right.
+func drawShadow(ctx *canvas.Context, w, h float64, shape func(ctx *canvas.Context, col color.Color)) {
+	col := color.NRGBAModel.Convert(optShadowColor).(color.NRGBA)
+	col.A = uint8(math.Round(float64(col.A) * optShadowOpacity))
+	c := canvas.New(w, h)
+	shape(canvas.NewContext(c), col)
+
+	resolution := canvas.DPMM(optResolution)
+	img := rasterize(c, resolution, 1)
+	blurred := boxBlur(img, optShadowBlur*optResolution)
+	putRGBA(img)
+	ctx.DrawImage(optShadowOffset, -optShadowOffset, blurred, float64(resolution))
+}
+
+// drawInnerShadow shades the inside edge of the shapes drawn by shape, as if
+// they were recessed below a light from the top left.
+func drawInnerShadow(ctx *canvas.Context, w, h float64, shape func(ctx *canvas.Context)) {
+	c := canvas.New(w, h)
+	shape(canvas.NewContext(c))
+
+	resolution := canvas.DPMM(optResolution)
+	mask := rasterize(c, resolution, 1)
+	defer putRGBA(mask)
+	d := int(math.Round(optShadowOffset * optResolution))
+	b := mask.Bounds()
+	img := getRGBA(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			a := uint8(255)
+			if p := image.Pt(x-d, y-d); p.In(b) {
+				a = 255 - mask.RGBAAt(p.X, p.Y).A
+			}
+			img.Pix[img.PixOffset(x, y)+3] = a
+		}
+	}
+	shade := boxBlur(img, optShadowBlur*optResolution)
+	putRGBA(img)
+	col := color.NRGBAModel.Convert(optShadowColor).(color.NRGBA)
+	opacity := float64(col.A) / 255 * optShadowOpacity
+	for i := 0; i < len(shade.Pix); i += 4 {
+		a := math.Round(float64(shade.Pix[i+3]) * float64(mask.Pix[i+3]) / 255 * opacity)
+		shade.Pix[i] = uint8(math.Round(float64(col.R) * a / 255))
+		shade.Pix[i+1] = uint8(math.Round(float64(col.G) * a / 255))
+		shade.Pix[i+2] = uint8(math.Round(float64(col.B) * a / 255))
+		shade.Pix[i+3] = uint8(a)
+	}
+	ctx.DrawImage(0, 0, shade, float64(resolution))
+}
+
+// drawGrain overlays per-pixel noise seeded from seed, lightening and darkening
+// by up to opacity so that flat backgrounds do not band.
+func drawGrain(ctx *canvas.Context, seed string, w, h, opacity float64) {
+	sum := sha256.Sum256([]byte(seed))
+	rnd := rand.New(rand.NewSource(int64(binary.BigEndian.Uint64(sum[:8]))))
+
+	resolution := canvas.DPMM(optResolution)
+	img := image.NewRGBA(image.Rect(0, 0, int(w*optResolution+0.5), int(h*optResolution+0.5)))
+	for i := 0; i < len(img.Pix); i += 4 {
+		n := rnd.Float64()*2 - 1
+		a := uint8(math.Round(math.Abs(n) * opacity * 255))
+		if n > 0 {
+			img.Pix[i], img.Pix[i+1], img.Pix[i+2] = a, a, a
+		}
+		img.Pix[i+3] = a
+	}
+	ctx.DrawImage(0, 0, img, float64(resolution))
+}
+
+var watermarkCorners = map[string][2]float64{
+	"top-left":     {0, 1},
+	"top-right":    {1, 1},
+	"bottom-left":  {0, 0},
+	"bottom-right": {1, 0},
+}
+
+func loadWatermark(name string, opacity float64) (img *image.RGBA, err error) {
+	var src image.Image
+	if src, err = loadPNG(name); err != nil {
+		return
+	}
+	img = image.NewRGBA(src.Bounds())
+	draw.Draw(img, img.Bounds(), src, src.Bounds().Min, draw.Src)
+	for i := range img.Pix {
+		img.Pix[i] = uint8(math.Round(float64(img.Pix[i]) * opacity))
+	}
+	return
+}
+
+// drawWatermark places img in a corner of the card, width millimetres wide
+// and margin millimetres from both edges.
+func drawWatermark(ctx *canvas.Context, img image.Image, corner string, w, h, width, margin float64) {
+	size := img.Bounds().Size()
+	height := width * float64(size.Y) / float64(size.X)
+	anchor := watermarkCorners[corner]
+	x := margin + anchor[0]*(w-2*margin-width)
+	y := margin + anchor[1]*(h-2*margin-height)
+	ctx.DrawImage(x, y, img, float64(size.X)/width)
+}
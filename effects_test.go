@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"github.com/tdewolff/canvas"
+	"image"
+	"testing"
+)
+
+// TestInnerShadowInsideBadge checks that -inner-shadow only shades pixels the
+// badge covers.
+func TestInnerShadowInsideBadge(t *testing.T) {
+	setupTestOptions(t)
+	logo, err := loadLogo("bitcoin-btc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(inner bool) { optInnerShadow = inner }(optInnerShadow)
+	render := func(inner bool) []uint8 {
+		optInnerShadow = inner
+		c, err := composeCard(logo, "Bitcoin", "1BoatSLRHtKNngkdXEeobR76b53LETtpyT")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rasterize(c, canvas.DPMM(optResolution), 1).Pix
+	}
+	plain, shaded := render(false), render(true)
+
+	shape, err := badgeShape(layout.Badge.Radius)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := canvas.New(layout.Width, layout.Height)
+	canvas.NewContext(c).DrawPath(layout.Badge.X, layout.Badge.Y, shape)
+	mask := rasterize(c, canvas.DPMM(optResolution), 1)
+
+	changed := 0
+	for i := 0; i < len(mask.Pix); i += 4 {
+		if plain[i] == shaded[i] && plain[i+1] == shaded[i+1] && plain[i+2] == shaded[i+2] && plain[i+3] == shaded[i+3] {
+			continue
+		}
+		changed++
+		if mask.Pix[i+3] == 0 {
+			x, y := i%mask.Stride/4, i/mask.Stride
+			t.Fatalf("pixel %d,%d outside the badge changed from %v to %v", x, y, plain[i:i+4], shaded[i:i+4])
+		}
+	}
+	if changed == 0 {
+		t.Error("the inner shadow changed no pixels")
+	}
+}
+
+// TestBoxBlurCopies checks that boxBlur returns an image of its own, so the
+// buffer it blurred can go back to the pool while the result is drawn.
+func TestBoxBlurCopies(t *testing.T) {
+	for _, sigma := range []float64{0, 3} {
+		img := image.NewRGBA(image.Rect(0, 0, 9, 7))
+		img.Pix[img.PixOffset(4, 3)+3] = 255
+		blurred := boxBlur(img, sigma)
+		want := append([]uint8{}, blurred.Pix...)
+		for i := range img.Pix {
+			img.Pix[i] = 1
+		}
+		if !bytes.Equal(blurred.Pix, want) {
+			t.Errorf("sigma %v: the blurred image changed with its input", sigma)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+func isFontCollection(buf []byte) bool {
+	return len(buf) >= 12 && string(buf[:4]) == "ttcf"
+}
+
+func isFontCollectionPath(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".ttc", ".otc":
+		return true
+	}
+	return false
+}
+
+// splitFontSelector splits "Helvetica.ttc#1" or "Helvetica.ttc#Helvetica Bold"
+// into the collection file and the selector of one of its faces.
+func splitFontSelector(path string) (file, selector string) {
+	if i := strings.LastIndexByte(path, '#'); i >= 0 && isFontCollectionPath(path[:i]) {
+		return path[:i], path[i+1:]
+	}
+	return path, ""
+}
+
+// splitFontCollection extracts the faces of a TrueType or OpenType collection
+// as standalone fonts, copying the tables each face uses.
+func splitFontCollection(buf []byte) (faces [][]byte, err error) {
+	if !isFontCollection(buf) {
+		return nil, errors.New("not a font collection")
+	}
+	n := int(binary.BigEndian.Uint32(buf[8:]))
+	if len(buf) < 12+4*n {
+		return nil, errors.New("font collection: bad header")
+	}
+	for i := 0; i < n; i++ {
+		offset := int(binary.BigEndian.Uint32(buf[12+4*i:]))
+		if offset+12 > len(buf) {
+			return nil, fmt.Errorf("font collection: bad offset of face %d", i)
+		}
+		numTables := int(binary.BigEndian.Uint16(buf[offset+4:]))
+		records := buf[offset+12:]
+		if len(records) < 16*numTables {
+			return nil, fmt.Errorf("font collection: bad table directory of face %d", i)
+		}
+
+		size := 12 + 16*numTables
+		face := make([]byte, size)
+		copy(face, buf[offset:offset+12])
+		for j := 0; j < numTables; j++ {
+			record := records[16*j : 16*j+16]
+			start, length := int(binary.BigEndian.Uint32(record[8:])), int(binary.BigEndian.Uint32(record[12:]))
+			if start < 0 || length < 0 || start+length > len(buf) {
+				return nil, fmt.Errorf("font collection: bad table %q of face %d", record[:4], i)
+			}
+			copy(face[12+16*j:], record[:8])
+			binary.BigEndian.PutUint32(face[12+16*j+8:], uint32(len(face)))
+			binary.BigEndian.PutUint32(face[12+16*j+12:], uint32(length))
+			face = append(face, buf[start:start+length]...)
+			for len(face)%4 != 0 {
+				face = append(face, 0)
+			}
+		}
+		faces = append(faces, face)
+	}
+	return
+}
+
+// selectCollectionFace picks a face of a collection by its index, or by its
+// full or family name, in which case the regular face is preferred.
+func selectCollectionFace(buf []byte, selector string) (face []byte, err error) {
+	var faces [][]byte
+	if faces, err = splitFontCollection(buf); err != nil {
+		return
+	}
+	if selector == "" {
+		selector = "0"
+	}
+	if i, errIndex := strconv.Atoi(selector); errIndex == nil {
+		if i < 0 || i >= len(faces) {
+			return nil, fmt.Errorf("font collection has %d faces, no face %d", len(faces), i)
+		}
+		return faces[i], nil
+	}
+	want, score := normalizeFontName(selector), 0
+	for _, f := range faces {
+		family, subfamily, full, errNames := fontNames(f)
+		if errNames != nil {
+			continue
+		}
+		if s := fontNameScore(want, family, subfamily, full); s > score {
+			face, score = f, s
+		}
+	}
+	if face == nil {
+		err = fmt.Errorf("no face named %q in font collection", selector)
+	}
+	return
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goitalic"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/sfnt"
+	"testing"
+)
+
+// testFontCollection packs fonts into a TrueType collection, laying out the
+// table directories of all faces first and their tables after them.
+func testFontCollection(fonts ...[]byte) []byte {
+	buf := make([]byte, 12+4*len(fonts))
+	copy(buf, "ttcf")
+	binary.BigEndian.PutUint32(buf[4:], 0x00010000)
+	binary.BigEndian.PutUint32(buf[8:], uint32(len(fonts)))
+	dataOffset := len(buf)
+	for _, f := range fonts {
+		dataOffset += 12 + 16*int(binary.BigEndian.Uint16(f[4:]))
+	}
+	var data []byte
+	for i, f := range fonts {
+		binary.BigEndian.PutUint32(buf[12+4*i:], uint32(len(buf)))
+		n := int(binary.BigEndian.Uint16(f[4:]))
+		dir := append([]byte{}, f[:12+16*n]...)
+		for j := 0; j < n; j++ {
+			record := dir[12+16*j:]
+			start, length := binary.BigEndian.Uint32(record[8:]), binary.BigEndian.Uint32(record[12:])
+			binary.BigEndian.PutUint32(record[8:], uint32(dataOffset+len(data)))
+			data = append(data, f[start:start+length]...)
+			for len(data)%4 != 0 {
+				data = append(data, 0)
+			}
+		}
+		buf = append(buf, dir...)
+	}
+	return append(buf, data...)
+}
+
+func TestSplitFontCollection(t *testing.T) {
+	fonts := [][]byte{goregular.TTF, gobold.TTF, goitalic.TTF}
+	faces, err := splitFontCollection(testFontCollection(fonts...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(faces) != len(fonts) {
+		t.Fatalf("split %d faces, want %d", len(faces), len(fonts))
+	}
+	for i, face := range faces {
+		_, got, err := readSFNTTables(face)
+		if err != nil {
+			t.Fatalf("face %d: %v", i, err)
+		}
+		_, want, _ := readSFNTTables(fonts[i])
+		if len(got) != len(want) {
+			t.Errorf("face %d has %d tables, want %d", i, len(got), len(want))
+		}
+		for tag, data := range want {
+			if !bytes.Equal(got[tag], data) {
+				t.Errorf("face %d: table %q differs", i, tag)
+			}
+		}
+
+		f, err := sfnt.Parse(face)
+		if err != nil {
+			t.Fatalf("face %d: sfnt.Parse: %v", i, err)
+		}
+		orig, _ := sfnt.Parse(fonts[i])
+		gotName, _ := f.Name(nil, sfnt.NameIDFull)
+		wantName, _ := orig.Name(nil, sfnt.NameIDFull)
+		if gotName != wantName || f.NumGlyphs() != orig.NumGlyphs() {
+			t.Errorf("face %d is %q with %d glyphs, want %q with %d", i, gotName, f.NumGlyphs(), wantName, orig.NumGlyphs())
+		}
+	}
+}
+
+func TestSplitFontCollectionErrors(t *testing.T) {
+	ttc := testFontCollection(goregular.TTF)
+	badOffset := append([]byte{}, ttc...)
+	binary.BigEndian.PutUint32(badOffset[12:], uint32(len(ttc)))
+	badTable := append([]byte{}, ttc...)
+	binary.BigEndian.PutUint32(badTable[16+12+8:], uint32(len(ttc)))
+	tests := []struct {
+		name string
+		buf  []byte
+	}{
+		{"not a collection", goregular.TTF},
+		{"truncated header", ttc[:14]},
+		{"bad face offset", badOffset},
+		{"truncated table directory", ttc[:16+20]},
+		{"bad table", badTable},
+	}
+	for _, tt := range tests {
+		if _, err := splitFontCollection(tt.buf); err == nil {
+			t.Errorf("%s: splitFontCollection succeeded, want an error", tt.name)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	canvasFont "github.com/tdewolff/canvas/font"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// FontEntry is a face found by scanFonts. Path names faces of collections as
+// "file.ttc#index". Weight is the CSS weight from 100 to 900.
+type FontEntry struct {
+	Path      string
+	Family    string
+	Subfamily string
+	Full      string
+	Weight    int
+	Italic    bool
+}
+
+var errStopFontWalk = errors.New("stop font walk")
+
+// fontEntry describes a face from its name table, and its OS/2 table for the
+// weight and italic flag, falling back to the subfamily name without one.
+func fontEntry(path string, buf []byte) (e FontEntry, err error) {
+	e.Path = path
+	if e.Family, e.Subfamily, e.Full, err = fontNames(buf); err != nil {
+		return
+	}
+	if buf, err = canvasFont.ToSFNT(buf); err != nil {
+		return
+	}
+	name := normalizeFontName(e.Subfamily)
+	e.Italic = strings.Contains(name, "italic") || strings.Contains(name, "oblique")
+	if _, tables, err := readSFNTTables(buf); err == nil && len(tables["OS/2"]) >= 64 {
+		os2 := tables["OS/2"]
+		e.Weight = int(binary.BigEndian.Uint16(os2[4:]))
+		e.Italic = e.Italic || binary.BigEndian.Uint16(os2[62:])&0x201 != 0
+	}
+	if e.Weight < 1 || e.Weight > 1000 {
+		e.Weight = 400
+		for _, k := range fontStyleKeywords {
+			if strings.Contains(name, k.Keyword) {
+				e.Weight = k.Weight
+				break
+			}
+		}
+	}
+	return
+}
+
+// matchFontWeight picks the face closest to weight and italic the way CSS
+// matches font-weight: faces of the requested slant are preferred, then for
+// weights from 400 to 500 the heavier faces up to 500, then lighter faces,
+// then heavier ones; lighter weights look lighter first and bolder weights
+// heavier first.
+func matchFontWeight(faces []FontEntry, weight int, italic bool) (best FontEntry) {
+	var candidates []FontEntry
+	for _, f := range faces {
+		if f.Italic == italic {
+			candidates = append(candidates, f)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = faces
+	}
+	rank := func(w int) int {
+		switch {
+		case weight >= 400 && weight <= 500:
+			if w >= weight && w <= 500 {
+				return w - weight
+			} else if w < weight {
+				return 1000 + weight - w
+			}
+			return 2000 + w
+		case weight < 400:
+			if w <= weight {
+				return weight - w
+			}
+			return 1000 + w
+		default:
+			if w >= weight {
+				return w - weight
+			}
+			return 1000 + weight - w
+		}
+	}
+	bestRank := -1
+	for _, f := range candidates {
+		if r := rank(f.Weight); bestRank < 0 || r < bestRank {
+			best, bestRank = f, r
+		}
+	}
+	return
+}
+
+// fontWeightFauxBold is the outline offset, as a fraction of the font size,
+// that a face lighter than 600 is emboldened by to stand in for a CSS weight
+// of 600 or more.
+func fontWeightFauxBold(weight int) float64 {
+	switch {
+	case weight < 650:
+		return 0.01
+	case weight < 750:
+		return 0.02
+	case weight < 850:
+		return 0.03
+	}
+	return 0.04
+}
+
+// walkFonts calls fn with every face of the fonts below dir, until fn returns
+// false. Files that cannot be read as fonts are skipped.
+func walkFonts(dir string, fn func(e FontEntry) bool) error {
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if p == dir {
+				return err
+			}
+			return nil
+		}
+		if info.IsDir() {
+			return nil
+		}
+		switch strings.ToLower(filepath.Ext(p)) {
+		case ".ttf", ".otf", ".ttc", ".otc", ".woff", ".woff2":
+		default:
+			return nil
+		}
+		buf, err := ioutil.ReadFile(p)
+		if err != nil {
+			return nil
+		}
+		faces, names := [][]byte{buf}, []string{p}
+		if isFontCollection(buf) {
+			if faces, err = splitFontCollection(buf); err != nil {
+				return nil
+			}
+			names = names[:0]
+			for i := range faces {
+				names = append(names, p+"#"+strconv.Itoa(i))
+			}
+		}
+		for i, face := range faces {
+			e, err := fontEntry(names[i], face)
+			if err != nil {
+				continue
+			}
+			if !fn(e) {
+				return errStopFontWalk
+			}
+		}
+		return nil
+	})
+	if err == errStopFontWalk {
+		err = nil
+	}
+	return err
+}
+
+// scanFonts indexes the faces of the fonts below dir by family, style and
+// weight, so that fonts can be referred to by name instead of by file.
+func scanFonts(dir string) (entries []FontEntry, err error) {
+	err = walkFonts(dir, func(e FontEntry) bool {
+		entries = append(entries, e)
+		return true
+	})
+	return
+}
+
+// listFonts prints the index of the given directories, or of the installed
+// fonts, for picking names to pass to -font.
+func listFonts(args []string) (err error) {
+	dirs := args
+	if len(dirs) == 0 {
+		dirs = systemFontDirs()
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "FULL NAME\tFAMILY\tSTYLE\tWEIGHT\tITALIC\tPATH")
+	for _, dir := range dirs {
+		var entries []FontEntry
+		if entries, err = scanFonts(dir); err != nil {
+			if len(args) == 0 && os.IsNotExist(err) {
+				err = nil
+				continue
+			}
+			return
+		}
+		for _, e := range entries {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%t\t%s\n", e.Full, e.Family, e.Subfamily, e.Weight, e.Italic, e.Path)
+		}
+	}
+	return w.Flush()
+}
@@ -0,0 +1,49 @@
+package main
+
+import "testing"
+
+func TestMatchFontWeight(t *testing.T) {
+	faces := func(weights ...int) (entries []FontEntry) {
+		for _, w := range weights {
+			entries = append(entries, FontEntry{Path: "upright", Weight: w})
+		}
+		return
+	}
+	italics := func(entries []FontEntry, weights ...int) []FontEntry {
+		for _, w := range weights {
+			entries = append(entries, FontEntry{Path: "italic", Weight: w, Italic: true})
+		}
+		return entries
+	}
+	full := faces(100, 300, 400, 500, 700, 900)
+	tests := []struct {
+		name       string
+		faces      []FontEntry
+		weight     int
+		italic     bool
+		wantWeight int
+		wantItalic bool
+	}{
+		{"exact", full, 400, false, 400, false},
+		{"normal prefers up to 500", full, 450, false, 500, false},
+		{"normal then lighter", faces(300, 400, 600), 450, false, 400, false},
+		{"500 then lighter", faces(300, 700), 500, false, 300, false},
+		{"normal then heavier", faces(600, 800), 400, false, 600, false},
+		{"bold prefers heavier", full, 600, false, 700, false},
+		{"bold then lighter", full, 950, false, 900, false},
+		{"light prefers lighter", full, 200, false, 100, false},
+		{"light then heavier", faces(300, 400), 200, false, 300, false},
+		{"italic", italics(full, 400, 700), 400, true, 400, true},
+		{"italic prefers slant over weight", italics(full, 400), 900, true, 400, true},
+		{"italic falls back to upright", full, 700, true, 700, false},
+		{"upright falls back to italic", italics(nil, 300, 700), 400, false, 300, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := matchFontWeight(tt.faces, tt.weight, tt.italic)
+			if got.Weight != tt.wantWeight || got.Italic != tt.wantItalic {
+				t.Errorf("matchFontWeight(%d, %v) = %d italic %v, want %d italic %v", tt.weight, tt.italic, got.Weight, got.Italic, tt.wantWeight, tt.wantItalic)
+			}
+		})
+	}
+}
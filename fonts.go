@@ -0,0 +1,353 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"github.com/tdewolff/canvas"
+	canvasFont "github.com/tdewolff/canvas/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/sfnt"
+	"io/fs"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+// fontDigest hashes the bytes of every font loaded into the card font family,
+// so that replacing a font file changes the cache keys of the cards.
+var fontDigest = sha256.New()
+
+var fontconfigDirPattern = regexp.MustCompile(`<dir(?:\s+prefix="(\w+)")?\s*>([^<]+)</dir>`)
+
+func fontconfigDirs() (dirs []string) {
+	files, _ := filepath.Glob(filepath.Join("/etc", "fonts", "conf.d", "*.conf"))
+	files = append([]string{filepath.Join("/etc", "fonts", "fonts.conf")}, files...)
+	home, _ := os.UserHomeDir()
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" && home != "" {
+		dataHome = filepath.Join(home, ".local", "share")
+	}
+	for _, file := range files {
+		buf, err := ioutil.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		for _, match := range fontconfigDirPattern.FindAllStringSubmatch(string(buf), -1) {
+			dir := strings.TrimSpace(match[2])
+			switch {
+			case match[1] == "xdg":
+				dir = filepath.Join(dataHome, dir)
+			case strings.HasPrefix(dir, "~/"):
+				dir = filepath.Join(home, dir[2:])
+			}
+			dirs = append(dirs, dir)
+		}
+	}
+	return
+}
+
+func systemFontDirs() (dirs []string) {
+	home, _ := os.UserHomeDir()
+	switch runtime.GOOS {
+	case "windows":
+		dirs = append(dirs, filepath.Join(os.Getenv("WINDIR"), "Fonts"))
+		if local := os.Getenv("LOCALAPPDATA"); local != "" {
+			dirs = append(dirs, filepath.Join(local, "Microsoft", "Windows", "Fonts"))
+		}
+	case "darwin":
+		dirs = append(dirs, "/System/Library/Fonts", "/Library/Fonts")
+		if home != "" {
+			dirs = append(dirs, filepath.Join(home, "Library", "Fonts"))
+		}
+	default:
+		dirs = fontconfigDirs()
+		if len(dirs) == 0 {
+			dirs = append(dirs, "/usr/share/fonts", "/usr/local/share/fonts")
+			if home != "" {
+				dirs = append(dirs, filepath.Join(home, ".local", "share", "fonts"), filepath.Join(home, ".fonts"))
+			}
+		}
+	}
+	return
+}
+
+func normalizeFontName(name string) string {
+	return strings.ToLower(strings.Join(strings.FieldsFunc(name, func(r rune) bool {
+		return r == ' ' || r == '-' || r == '_'
+	}), ""))
+}
+
+func fontNames(buf []byte) (family, subfamily, full string, err error) {
+	if buf, err = canvasFont.ToSFNT(buf); err != nil {
+		return
+	}
+	var f *sfnt.Font
+	if f, err = sfnt.Parse(buf); err != nil {
+		return
+	}
+	b := &sfnt.Buffer{}
+	family, _ = f.Name(b, sfnt.NameIDFamily)
+	subfamily, _ = f.Name(b, sfnt.NameIDSubfamily)
+	full, _ = f.Name(b, sfnt.NameIDFull)
+	return
+}
+
+// fontNameScore rates how well a face matches a normalized font name: 3 for
+// its full name, 2 for the regular face of its family and 1 for its family.
+func fontNameScore(want, family, subfamily, full string) int {
+	switch {
+	case normalizeFontName(full) == want:
+		return 3
+	case normalizeFontName(family) == want && normalizeFontName(subfamily) == "regular":
+		return 2
+	case normalizeFontName(family) == want:
+		return 1
+	}
+	return 0
+}
+
+// findSystemFont looks up a font by its full name ("DejaVu Sans Bold") or by
+// its family name, in which case the face closest to -font-weight and
+// -font-italic is preferred. -font-dir is
+// searched before the installed fonts. Faces of font collections are returned
+// as "file.ttc#index".
+func findSystemFont(name string) (path string, err error) {
+	want := normalizeFontName(name)
+	score := 0
+	var family []FontEntry
+	dirs := systemFontDirs()
+	if optFontDir != "" {
+		dirs = append([]string{optFontDir}, dirs...)
+	}
+	for _, dir := range dirs {
+		_ = walkFonts(dir, func(e FontEntry) bool {
+			s := fontNameScore(want, e.Family, e.Subfamily, e.Full)
+			if s > score {
+				score, path = s, e.Path
+			}
+			if s > 0 && s < 3 {
+				family = append(family, e)
+			}
+			return score < 3
+		})
+		if score == 3 {
+			break
+		}
+	}
+	if score < 3 && len(family) > 0 {
+		path = matchFontWeight(family, optFontWeight, optFontItalic).Path
+	}
+	if path == "" {
+		err = fmt.Errorf("font not found: %s", name)
+	}
+	return
+}
+
+func resolveFont(name string) (path string, err error) {
+	file, _ := splitFontSelector(name)
+	if _, err = os.Stat(file); err == nil {
+		return name, nil
+	}
+	return findSystemFont(name)
+}
+
+var fontStyleKeywords = []struct {
+	Keyword string
+	Style   canvas.FontStyle
+	Weight  int
+}{
+	{"extralight", canvas.FontExtraLight, 200},
+	{"ultralight", canvas.FontExtraLight, 200},
+	{"thin", canvas.FontExtraLight, 100},
+	{"light", canvas.FontLight, 300},
+	{"book", canvas.FontBook, 400},
+	{"medium", canvas.FontMedium, 500},
+	{"semibold", canvas.FontSemibold, 600},
+	{"demibold", canvas.FontSemibold, 600},
+	{"extrabold", canvas.FontBlack, 800},
+	{"ultrabold", canvas.FontBlack, 800},
+	{"extrablack", canvas.FontExtraBlack, 900},
+	{"black", canvas.FontBlack, 900},
+	{"heavy", canvas.FontBlack, 900},
+	{"bold", canvas.FontBold, 700},
+}
+
+func fontStyleFromSubfamily(subfamily string) (style canvas.FontStyle) {
+	name := normalizeFontName(subfamily)
+	for _, k := range fontStyleKeywords {
+		if strings.Contains(name, k.Keyword) {
+			style = k.Style
+			break
+		}
+	}
+	if strings.Contains(name, "italic") || strings.Contains(name, "oblique") {
+		style |= canvas.FontItalic
+	}
+	return
+}
+
+// loadFontFS loads the font at path into family. A face of a font collection
+// is selected as in splitFontSelector, and defaults to the first.
+func loadFontFS(family *canvas.FontFamily, fsys fs.FS, path string, style canvas.FontStyle) (err error) {
+	path, selector := splitFontSelector(path)
+	var buf []byte
+	if buf, err = fs.ReadFile(fsys, path); err != nil {
+		return
+	}
+	if isFontCollection(buf) {
+		if buf, err = selectCollectionFace(buf, selector); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	fontDigest.Write(buf)
+	if err = family.LoadFont(buf, style); err != nil {
+		return
+	}
+	addLoadedFace(path, buf, style)
+	return
+}
+
+// loadFontsFS loads every font below root into family, picking the style of
+// each face from its subfamily name.
+func loadFontsFS(family *canvas.FontFamily, fsys fs.FS, root string) (n int, err error) {
+	err = fs.WalkDir(fsys, root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".ttf", ".otf", ".woff", ".woff2", ".ttc", ".otc":
+		default:
+			return nil
+		}
+		buf, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			return err
+		}
+		faces := [][]byte{buf}
+		if isFontCollection(buf) {
+			if faces, err = splitFontCollection(buf); err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+		}
+		for _, face := range faces {
+			_, subfamily, _, err := fontNames(face)
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			style := fontStyleFromSubfamily(subfamily)
+			if err = family.LoadFont(face, style); err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			addLoadedFace(path, face, style)
+			fontDigest.Write(face)
+			n++
+		}
+		return nil
+	})
+	return
+}
+
+// loadedFace is a face of the card font family and the style it is loaded as.
+type loadedFace struct {
+	FontEntry
+	Style canvas.FontStyle
+}
+
+var loadedFaces []loadedFace
+
+// textFaceStyle is the loaded face of the card font family that cards are
+// written in, and the bold, as a fraction of the font size, and slant that are
+// synthesized when no loaded face has the weight or italic asked for.
+type textFaceStyle struct {
+	Style      canvas.FontStyle
+	FauxBold   float64
+	FauxItalic float64
+}
+
+var textFace = textFaceStyle{Style: canvas.FontRegular}
+
+// addLoadedFace records a face loaded into the card font family, replacing
+// the face it took the style of.
+func addLoadedFace(path string, buf []byte, style canvas.FontStyle) {
+	e, err := fontEntry(path, buf)
+	if err != nil {
+		return
+	}
+	for i := range loadedFaces {
+		if loadedFaces[i].Style == style {
+			loadedFaces[i] = loadedFace{e, style}
+			return
+		}
+	}
+	loadedFaces = append(loadedFaces, loadedFace{e, style})
+}
+
+// matchTextStyle picks the loaded face closest to weight and italic. When the
+// face is lighter than a bold weight or upright for an italic, it is
+// emboldened or slanted.
+func matchTextStyle(weight int, italic bool) (t textFaceStyle) {
+	t.Style = canvas.FontRegular
+	if len(loadedFaces) == 0 {
+		return
+	}
+	entries := make([]FontEntry, len(loadedFaces))
+	for i, f := range loadedFaces {
+		entries[i] = f.FontEntry
+	}
+	best := matchFontWeight(entries, weight, italic)
+	for _, f := range loadedFaces {
+		if f.FontEntry != best {
+			continue
+		}
+		t.Style = f.Style
+		if weight >= 600 && best.Weight < 600 {
+			t.FauxBold = fontWeightFauxBold(weight)
+		}
+		if italic && !best.Italic {
+			t.FauxItalic = 0.3
+		}
+		return
+	}
+	return
+}
+
+func loadFontFamily() (family *canvas.FontFamily, err error) {
+	family = canvas.NewFontFamily("Custom")
+	family.Use(canvas.CommonLigatures)
+	loadedFaces = nil
+	defer func() {
+		if err == nil {
+			textFace = matchTextStyle(optFontWeight, optFontItalic)
+		}
+	}()
+
+	if optFontDir != "" && optFont == "" {
+		var n int
+		if n, err = loadFontsFS(family, os.DirFS(optFontDir), "."); err != nil {
+			return
+		}
+		log.Println("loaded", n, "fonts from", optFontDir)
+		return
+	}
+
+	fontPath := optFont
+	if fontPath == "" {
+		fontPath = filepath.Join("src", "custom-font.ttf")
+		if _, err = os.Stat(fontPath); os.IsNotExist(err) {
+			log.Println("no font configured, using embedded Go Regular")
+			fontDigest.Write(goregular.TTF)
+			if err = family.LoadFont(goregular.TTF, canvas.FontRegular); err == nil {
+				addLoadedFace("goregular", goregular.TTF, canvas.FontRegular)
+			}
+			return
+		}
+	} else if fontPath, err = resolveFont(fontPath); err != nil {
+		return
+	}
+	err = loadFontFS(family, os.DirFS(filepath.Dir(fontPath)), filepath.Base(fontPath), canvas.FontRegular)
+	return
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"github.com/tdewolff/canvas"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goitalic"
+	"golang.org/x/image/font/gofont/goregular"
+	"testing"
+)
+
+func TestMatchTextStyle(t *testing.T) {
+	tests := []struct {
+		name   string
+		font   []byte
+		style  canvas.FontStyle
+		weight int
+		italic bool
+		want   textFaceStyle
+	}{
+		{"regular", goregular.TTF, canvas.FontRegular, 400, false, textFaceStyle{Style: canvas.FontRegular}},
+		{"regular as bold", goregular.TTF, canvas.FontRegular, 700, false, textFaceStyle{Style: canvas.FontRegular, FauxBold: 0.02}},
+		{"regular as italic", goregular.TTF, canvas.FontRegular, 400, true, textFaceStyle{Style: canvas.FontRegular, FauxItalic: 0.3}},
+		{"bold only as italic", gobold.TTF, canvas.FontBold, 400, true, textFaceStyle{Style: canvas.FontBold, FauxItalic: 0.3}},
+		{"italic only as bold", goitalic.TTF, canvas.FontItalic, 700, false, textFaceStyle{Style: canvas.FontItalic, FauxBold: 0.02}},
+		{"italic only as bold italic", goitalic.TTF, canvas.FontItalic, 700, true, textFaceStyle{Style: canvas.FontItalic, FauxBold: 0.02}},
+	}
+	defer func() { loadedFaces = nil }()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			family := canvas.NewFontFamily("test")
+			if err := family.LoadFont(tt.font, tt.style); err != nil {
+				t.Fatal(err)
+			}
+			loadedFaces = nil
+			addLoadedFace(tt.name, tt.font, tt.style)
+
+			got := matchTextStyle(tt.weight, tt.italic)
+			if got != tt.want {
+				t.Fatalf("matchTextStyle(%d, %v) = %+v, want %+v", tt.weight, tt.italic, got, tt.want)
+			}
+			// Face panics for styles that were never loaded.
+			family.Face(12, canvas.Black, got.Style, canvas.FontNormal)
+		})
+	}
+}
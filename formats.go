@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/tdewolff/canvas"
+	"github.com/tdewolff/canvas/pdf"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// cardWriter encodes a card. Writers that take long, such as the raster
+// formats, give up once ctx is done.
+type cardWriter func(ctx context.Context, w io.Writer, c *canvas.Canvas) error
+
+type cardFormat struct {
+	MIMEType string
+	Writer   cardWriter
+}
+
+// canvasWriter adapts a canvas writer, which runs to completion, to a cardWriter.
+func canvasWriter(writer canvas.Writer) cardWriter {
+	return func(ctx context.Context, w io.Writer, c *canvas.Canvas) error {
+		return writer(w, c)
+	}
+}
+
+var cardFormats = map[string]cardFormat{}
+
+// registerFormat makes a card format selectable with -formats. It panics when
+// name is taken, so it is meant to be called from init.
+func registerFormat(name string, format cardFormat) {
+	if _, ok := cardFormats[name]; ok {
+		panic("format already registered: " + name)
+	}
+	cardFormats[name] = format
+}
+
+func formatNames() (names []string) {
+	for name := range cardFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+func init() {
+	registerFormat("png", cardFormat{MIMEType: "image/png", Writer: pngWriter})
+	registerFormat("jpg", cardFormat{MIMEType: "image/jpeg", Writer: jpegWriter})
+	registerFormat("webp", cardFormat{MIMEType: "image/webp", Writer: webpWriter})
+	registerFormat("pdf", cardFormat{MIMEType: "application/pdf", Writer: canvasWriter(pdf.Writer)})
+	registerFormat("svg", cardFormat{MIMEType: "image/svg+xml", Writer: canvasWriter(svgWriter)})
+}
+
+// errRenderTimeout is returned when drawing and encoding a card takes longer
+// than -render-timeout.
+var errRenderTimeout = errors.New("render timed out")
+
+// writeCard writes the card in format to w, drawing it into *c with draw
+// unless an earlier format already did.
+//
+// With -render-timeout, drawing and encoding share the budget. When it runs
+// out, the work is abandoned rather than aborted: the raster writers stop at
+// the next band or stage, but text layout and vector formats run to the end
+// in the background, and their result is dropped.
+func writeCard(w io.Writer, c **canvas.Canvas, draw func() (*canvas.Canvas, error), format string) (err error) {
+	f, ok := cardFormats[format]
+	if !ok {
+		return fmt.Errorf("unknown format: %s", format)
+	}
+	if optRenderTimeout <= 0 {
+		if *c == nil {
+			if *c, err = draw(); err != nil {
+				return
+			}
+		}
+		return encodeCard(context.Background(), w, *c, f)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), optRenderTimeout)
+	defer cancel()
+	type result struct {
+		c   *canvas.Canvas
+		buf *bytes.Buffer
+		err error
+	}
+	done := make(chan result, 1)
+	go func(drawn *canvas.Canvas) {
+		r := result{c: drawn, buf: &bytes.Buffer{}}
+		if r.c == nil {
+			r.c, r.err = draw()
+		}
+		if r.err == nil {
+			r.err = ctx.Err()
+		}
+		if r.err == nil {
+			r.err = encodeCard(ctx, r.buf, r.c, f)
+		}
+		done <- r
+	}(*c)
+	var r result
+	select {
+	case r = <-done:
+	case <-ctx.Done():
+		return fmt.Errorf("%s after %s: %w", format, optRenderTimeout, errRenderTimeout)
+	}
+	if r.c != nil {
+		*c = r.c
+	}
+	if errors.Is(r.err, context.DeadlineExceeded) {
+		return fmt.Errorf("%s after %s: %w", format, optRenderTimeout, errRenderTimeout)
+	} else if r.err != nil {
+		return r.err
+	}
+	_, err = r.buf.WriteTo(w)
+	return
+}
+
+func encodeCard(ctx context.Context, w io.Writer, c *canvas.Canvas, f cardFormat) error {
+	cw := &countingWriter{Writer: w}
+	start := time.Now()
+	defer func() {
+		addStats(renderStats{Files: 1, Bytes: cw.n, Encode: time.Since(start)})
+	}()
+	return f.Writer(ctx, cw, c)
+}
+
+func newDataURIWriter(w io.Writer, mimeType string) (enc io.WriteCloser, err error) {
+	if _, err = io.WriteString(w, "data:"+mimeType+";base64,"); err != nil {
+		return
+	}
+	enc = base64.NewEncoder(base64.StdEncoding, w)
+	return
+}
+
+func writeFile(name string, write func(w io.Writer) error) (err error) {
+	var f *os.File
+	if f, err = os.Create(name); err != nil {
+		return
+	}
+	defer func() {
+		if errClose := f.Close(); err == nil {
+			err = errClose
+		}
+		if err != nil {
+			os.Remove(name)
+		}
+	}()
+	bw := bufio.NewWriter(f)
+	if err = write(bw); err != nil {
+		return
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"github.com/tdewolff/canvas"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// gen renders a single card to stdout, or to -o, so that it can be used in
+// shell pipelines. With -stdin, or a lone - argument as in "gen -stdin -", the
+// address is read from standard input.
+func gen(args []string) (err error) {
+	return genCard(args, os.Stdin, os.Stdout)
+}
+
+func genCard(args []string, stdin io.Reader, stdout io.Writer) (err error) {
+	fset := flag.NewFlagSet("gen", flag.ExitOnError)
+	id := fset.String("id", "", "logo id, as in src/logos/<id>-logo.png")
+	name := fset.String("name", "", "name printed on the card")
+	address := fset.String("address", "", "address encoded in the card")
+	readStdin := fset.Bool("stdin", false, "read the address from standard input, as does a - argument")
+	format := fset.String("format", "png", "card format, any of "+strings.Join(formatNames(), ", "))
+	output := fset.String("o", "-", "file to write, - for standard output")
+	if err = fset.Parse(args); err != nil {
+		return
+	}
+	for fset.NArg() > 0 && fset.Arg(0) == "-" {
+		*readStdin = true
+		if err = fset.Parse(fset.Args()[1:]); err != nil {
+			return
+		}
+	}
+	if fset.NArg() > 0 {
+		return errors.New("gen: unexpected arguments: " + strings.Join(fset.Args(), " "))
+	}
+	if _, ok := cardFormats[*format]; !ok {
+		return &ValidationError{Field: "format", Value: *format, Reason: "must be one of " + strings.Join(formatNames(), ", ")}
+	}
+	if *readStdin {
+		var buf []byte
+		if buf, err = ioutil.ReadAll(io.LimitReader(stdin, int64(optMaxInputLength)*4+1)); err != nil {
+			return
+		}
+		*address = strings.TrimSpace(string(buf))
+	}
+
+	i, n, a := normalizeItem(*id, *name, *address)
+	if err = validateItem(i, n, a); err != nil {
+		return
+	}
+	var c *canvas.Canvas
+	if *output != "-" {
+		return writeFile(*output, func(w io.Writer) error {
+			return renderCard(w, &c, i, n, a, *format)
+		})
+	}
+	w := bufio.NewWriter(stdout)
+	if err = renderCard(w, &c, i, n, a, *format); err != nil {
+		return
+	}
+	return w.Flush()
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestGenStdin(t *testing.T) {
+	setupTestOptions(t)
+	const address = "1BoatSLRHtKNngkdXEeobR76b53LETtpyT"
+	card := []string{"-id", "bitcoin-btc", "-name", "Bitcoin", "-format", "svg"}
+	want := &bytes.Buffer{}
+	if err := genCard(append(card, "-address", address), strings.NewReader(""), want); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"--stdin -", append(append([]string{}, card...), "--stdin", "-")},
+		{"--stdin - before flags", append([]string{"--stdin", "-"}, card...)},
+		{"-stdin", append(append([]string{}, card...), "-stdin")},
+		{"-", append(append([]string{}, card...), "-")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := &bytes.Buffer{}
+			if err := genCard(tt.args, strings.NewReader(address+"\n"), got); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got.Bytes(), want.Bytes()) {
+				t.Error("card differs from the one rendered with -address")
+			}
+		})
+	}
+
+	if err := genCard(append(append([]string{}, card...), "--stdin", "-", "extra"), strings.NewReader(address), &bytes.Buffer{}); err == nil {
+		t.Error("an argument after - was accepted")
+	}
+}
@@ -1,9 +1,13 @@
 module github.com/guoyk93/persona
 
-go 1.15
+go 1.16
 
 require (
+	github.com/bradfitz/gomemcache v0.0.0-20220106215444-fb4bf637b56d
 	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
 	github.com/tdewolff/canvas v0.0.0-20210210144621-f73efe64c447
+	golang.org/x/image v0.0.0-20200924062109-4578eab98f00
+	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c
+	golang.org/x/text v0.3.3
 	gopkg.in/yaml.v2 v2.4.0
 )
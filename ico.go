@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"io"
+)
+
+func encodeICO(w io.Writer, imgs []image.Image) (err error) {
+	var payloads [][]byte
+	for _, img := range imgs {
+		b := &bytes.Buffer{}
+		if err = pngEncoder.Encode(b, img); err != nil {
+			return
+		}
+		payloads = append(payloads, b.Bytes())
+	}
+
+	header := []uint16{0, 1, uint16(len(imgs))}
+	if err = binary.Write(w, binary.LittleEndian, header); err != nil {
+		return
+	}
+
+	offset := uint32(6 + 16*len(imgs))
+	for i, img := range imgs {
+		size := img.Bounds().Size()
+		entry := struct {
+			Width       uint8
+			Height      uint8
+			ColorCount  uint8
+			Reserved    uint8
+			Planes      uint16
+			BitCount    uint16
+			BytesInRes  uint32
+			ImageOffset uint32
+		}{
+			Width:       uint8(size.X % 256),
+			Height:      uint8(size.Y % 256),
+			Planes:      1,
+			BitCount:    32,
+			BytesInRes:  uint32(len(payloads[i])),
+			ImageOffset: offset,
+		}
+		if err = binary.Write(w, binary.LittleEndian, entry); err != nil {
+			return
+		}
+		offset += entry.BytesInRes
+	}
+
+	for _, payload := range payloads {
+		if _, err = w.Write(payload); err != nil {
+			return
+		}
+	}
+	return
+}
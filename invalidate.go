@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+)
+
+// invalidate removes the cached cards of the given ids, or of every id with -all.
+func invalidate(args []string) (err error) {
+	fset := flag.NewFlagSet("invalidate", flag.ExitOnError)
+	all := fset.Bool("all", false, "remove every cached card")
+	if err = fset.Parse(args); err != nil {
+		return
+	}
+	if !persistentCache() {
+		return errors.New("invalidate: no persistent cache, set -cache-dir or -memcached")
+	}
+	if *all {
+		if fset.NArg() > 0 {
+			return errors.New("invalidate: -all takes no ids")
+		}
+		if err = invalidateAll(); err == nil {
+			log.Println("invalidate: removed every card")
+		}
+		return
+	}
+	if fset.NArg() == 0 {
+		return errors.New("invalidate: no ids given, use -all to remove every card")
+	}
+	for _, id := range fset.Args() {
+		if err = invalidateSeed(id); err != nil {
+			return
+		}
+		log.Println("invalidate:", id)
+	}
+	return
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/tdewolff/canvas"
+	"gopkg.in/yaml.v2"
+	"io"
+	"io/ioutil"
+)
+
+// Layout places the layers of a card, in millimetres from the bottom left
+// corner, except for the text box whose x and y are its top left corner. It
+// can be loaded from a YAML file with -layout, where omitted fields keep their
+// default.
+type Layout struct {
+	Width   float64 `yaml:"width"`
+	Height  float64 `yaml:"height"`
+	Artwork struct {
+		X    float64 `yaml:"x"`
+		Y    float64 `yaml:"y"`
+		Size float64 `yaml:"size"`
+	} `yaml:"artwork"`
+	Badge struct {
+		X      float64 `yaml:"x"`
+		Y      float64 `yaml:"y"`
+		Radius float64 `yaml:"radius"`
+	} `yaml:"badge"`
+	Logo struct {
+		X    float64 `yaml:"x"`
+		Y    float64 `yaml:"y"`
+		Size float64 `yaml:"size"`
+	} `yaml:"logo"`
+	Border struct {
+		Inset float64 `yaml:"inset"`
+		Width float64 `yaml:"width"`
+	} `yaml:"border"`
+	Text struct {
+		X        float64 `yaml:"x"`
+		Y        float64 `yaml:"y"`
+		Width    float64 `yaml:"width"`
+		Height   float64 `yaml:"height"`
+		FontSize float64 `yaml:"font_size"`
+		Align    string  `yaml:"align"`
+		VAlign   string  `yaml:"valign"`
+	} `yaml:"text"`
+}
+
+func defaultLayout() (l Layout) {
+	l.Width, l.Height = 600, 800
+	l.Artwork.X, l.Artwork.Y, l.Artwork.Size = (600.0-512.0)/2.0, (800.0-512.0)-((600.0-512.0)/2.0), 512
+	l.Badge.X, l.Badge.Y, l.Badge.Radius = 297, 500, 50
+	l.Logo.X, l.Logo.Y, l.Logo.Size = 265, 469, 64
+	l.Border.Inset, l.Border.Width = 20, 4
+	l.Text.X, l.Text.Y, l.Text.Width, l.Text.Height, l.Text.FontSize = 0, 250, 600, 200, 128
+	l.Text.Align, l.Text.VAlign = "center", "center"
+	return
+}
+
+var layout = defaultLayout()
+
+var textAligns = map[string]canvas.TextAlign{
+	"left":    canvas.Left,
+	"center":  canvas.Center,
+	"right":   canvas.Right,
+	"justify": canvas.Justify,
+	"top":     canvas.Top,
+	"bottom":  canvas.Bottom,
+}
+
+func loadLayout(name string) (l Layout, err error) {
+	l = defaultLayout()
+	var buf []byte
+	if buf, err = ioutil.ReadFile(name); err != nil {
+		return
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(buf))
+	dec.SetStrict(true)
+	if err = dec.Decode(&l); err != nil && err != io.EOF {
+		err = fmt.Errorf("%s: %w", name, err)
+		return
+	}
+	err = nil
+	for _, align := range []string{l.Text.Align, l.Text.VAlign} {
+		if _, ok := textAligns[align]; !ok {
+			err = &ValidationError{Field: "text alignment", Value: align, Reason: "must be left, center, right, justify, top or bottom"}
+			return
+		}
+	}
+	if l.Width <= 0 || l.Height <= 0 {
+		err = &ValidationError{Field: "layout size", Value: fmt.Sprintf("%gx%g", l.Width, l.Height), Reason: "must be positive"}
+	}
+	return
+}
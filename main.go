@@ -1,20 +1,26 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"flag"
 	"fmt"
-	"github.com/skip2/go-qrcode"
 	"github.com/tdewolff/canvas"
-	"github.com/tdewolff/canvas/rasterizer"
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/sync/singleflight"
 	"gopkg.in/yaml.v2"
 	"image"
 	"image/color"
+	"image/jpeg"
 	"image/png"
 	"io"
 	"io/ioutil"
 	"log"
+	"math"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 )
 
 type Item struct {
@@ -26,6 +32,79 @@ type Item struct {
 
 var fontFamily *canvas.FontFamily
 
+var jsMain func()
+
+var watermark *image.RGBA
+
+var renderFlights singleflight.Group
+
+var gray = color.RGBA{R: 51, G: 51, B: 51, A: 255}
+
+var (
+	faviconSizes        = []int{16, 32, 48}
+	touchIconSizes      = []int{120, 152, 167, 180}
+	optFormats          string
+	optFont             string
+	optFontDir          string
+	optFontWeight       int
+	optFontItalic       bool
+	optFauxBold         float64
+	optFauxItalic       float64
+	optStyle            string
+	optPattern          string
+	optPalette          string
+	optDataURI          bool
+	optParallelism      int
+	optResolution       float64
+	optSupersample      int
+	optResample         string
+	optPNGColors        int
+	optPNGDither        bool
+	optPNGCompression   string
+	optJPEGQuality      int
+	optCacheDir         string
+	optCacheMax         int64
+	optMemoryCacheMax   int64
+	optMemcached        string
+	optCacheTTL         time.Duration
+	cardCache           Cache
+	optMaxInputLength   int
+	optFoldCase         bool
+	optMaxPixels        int
+	optRenderTimeout    time.Duration
+	optShadow           bool
+	optInnerShadow      bool
+	optShadowOffset     float64
+	optShadowBlur       float64
+	optShadowOpacity    float64
+	optShadowColor      = color.RGBA{A: 255}
+	optBackground       = color.RGBA{R: 255, G: 255, B: 255, A: 255}
+	optMatte            = color.RGBA{R: 255, G: 255, B: 255, A: 255}
+	optBadgeShape       string
+	optSquircleExponent float64
+	optSquircleRadius   float64
+	optCornerSmoothing  float64
+	optPolygonSides     int
+	optPolygonRotation  float64
+	optPolygonRounding  float64
+	optBadgePath        string
+	optWatermark        string
+	optWatermarkCorner  string
+	optWatermarkWidth   float64
+	optWatermarkMargin  float64
+	optWatermarkOpacity float64
+	optGrain            float64
+	optStats            bool
+	optLayout           string
+	optSVGText          string
+	optSVGResponsive    bool
+	optFavicon          bool
+	optAnimate          string
+	optAnimation        string
+	optFrames           int
+	optDuration         time.Duration
+)
+
 func main() {
 	var err error
 	defer func(err *error) {
@@ -34,9 +113,79 @@ func main() {
 		}
 	}(&err)
 
-	fontFamily = canvas.NewFontFamily("Custom")
-	fontFamily.Use(canvas.CommonLigatures)
-	if err = fontFamily.LoadFontFile(filepath.Join("src", "custom-font.ttf"), canvas.FontRegular); err != nil {
+	defineFlags()
+	flag.Parse()
+
+	if jsMain != nil {
+		jsMain()
+		return
+	}
+
+	if optLayout != "" {
+		if layout, err = loadLayout(optLayout); err != nil {
+			return
+		}
+	}
+
+	if err = validateOptions(); err != nil {
+		return
+	}
+	pngEncoder.CompressionLevel = pngCompressionLevels[optPNGCompression]
+
+	if optWatermark != "" {
+		if watermark, err = loadWatermark(optWatermark, optWatermarkOpacity); err != nil {
+			return
+		}
+	}
+
+	if fontFamily, err = loadFontFamily(); err != nil {
+		return
+	}
+
+	var caches tieredCache
+	if optMemoryCacheMax > 0 {
+		caches = append(caches, newMemoryCache(optMemoryCacheMax))
+	}
+	if optCacheDir != "" {
+		var disk *diskCache
+		if disk, err = newDiskCache(optCacheDir, optCacheMax); err != nil {
+			return
+		}
+		caches = append(caches, disk)
+	}
+	if optMemcached != "" {
+		var remote *memcachedCache
+		if remote, err = newMemcachedCache(strings.Split(optMemcached, ",")); err != nil {
+			return
+		}
+		caches = append(caches, remote)
+	}
+	switch len(caches) {
+	case 0:
+	case 1:
+		cardCache = caches[0]
+	default:
+		cardCache = caches
+	}
+
+	switch flag.Arg(0) {
+	case "soak":
+		err = soak(flag.Args()[1:])
+		return
+	case "batch":
+		err = batch(flag.Args()[1:])
+		return
+	case "gen":
+		err = gen(flag.Args()[1:])
+		return
+	case "warm":
+		err = warm(flag.Args()[1:])
+		return
+	case "invalidate":
+		err = invalidate(flag.Args()[1:])
+		return
+	case "fonts":
+		err = listFonts(flag.Args()[1:])
 		return
 	}
 
@@ -74,65 +223,320 @@ func main() {
 	if err = ioutil.WriteFile("README.md", md.Bytes(), 0640); err != nil {
 		return
 	}
+	if optStats {
+		log.Println("stats total", statsSnapshot())
+	}
+}
+
+// defineFlags defines the options on the command line flag set, setting
+// them to their defaults.
+func defineFlags() {
+	flag.StringVar(&optFormats, "formats", "png", "comma separated card formats to generate, any of "+strings.Join(formatNames(), ", "))
+	flag.Var(colorValue{&optBackground}, "background", "CSS color of the card background, may be translucent or transparent")
+	flag.Var(colorValue{&optMatte}, "matte", "CSS color that jpg cards are flattened onto, since jpg has no alpha")
+	flag.IntVar(&optPNGColors, "png-colors", 0, "write png cards as indexed images of at most this many colours, 0 keeps full colour")
+	flag.BoolVar(&optPNGDither, "png-dither", true, "dither indexed png cards that have more colours than -png-colors")
+	flag.StringVar(&optPNGCompression, "png-compression", "default", "zlib compression of png output, default, none, speed or best")
+	flag.IntVar(&optJPEGQuality, "jpeg-quality", jpeg.DefaultQuality, "quality of jpg cards, 1 to 100")
+	flag.StringVar(&optFont, "font", "", "font file, a face of a collection as file.ttc#index or file.ttc#name, or name of an installed font (default src/custom-font.ttf, or the embedded Go Regular if that is missing)")
+	flag.IntVar(&optFontWeight, "font-weight", 400, "CSS weight of the card text from 100 to 900, matched against the loaded faces")
+	flag.BoolVar(&optFontItalic, "font-italic", false, "prefer an italic face for the card text")
+	flag.Float64Var(&optFauxItalic, "faux-italic", 0, "slant in degrees of italics synthesized for -font-italic, and the slant italic faces are sheared to match, 0 keeps the default and leaves italic faces as designed")
+	flag.Float64Var(&optFauxBold, "faux-bold", 0, "outline offset, as a fraction of the font size, used to embolden faces synthesized for a -font-weight of 600 or more, 0 keeps the default for the weight")
+	flag.StringVar(&optFontDir, "font-dir", "", "load every font face in a directory, or with -font, look font names up there first")
+	flag.StringVar(&optStyle, "style", "qrcode", "card artwork style, one of "+strings.Join(styleNames(), ", "))
+	flag.StringVar(&optPattern, "pattern", "none", "card background pattern, none, stripes, dots, chevrons or split")
+	flag.Var(colorValue{&gray}, "color", "CSS color of the text, border and QR code")
+	flag.StringVar(&optPalette, "palette", "vivid", "colour palette of the ring style, one of "+strings.Join(paletteNames(), ", ")+", or a CSS color whose lightness and chroma it keeps")
+	flag.IntVar(&optParallelism, "parallelism", 1, "number of horizontal bands rasterized concurrently")
+	flag.Float64Var(&optResolution, "resolution", 1, "pixels per millimetre of rasterized cards")
+	flag.IntVar(&optSupersample, "supersample", 1, "rasterize at this many times the resolution and downscale with -resample")
+	flag.StringVar(&optResample, "resample", "catmull-rom", "kernel for downscaling supersampled cards and favicons, nearest, bilinear, catmull-rom or lanczos")
+	flag.StringVar(&optCacheDir, "cache-dir", "", "directory to cache rendered cards in, skipping renders whose inputs did not change")
+	flag.Int64Var(&optCacheMax, "cache-max-bytes", 256<<20, "size of -cache-dir beyond which least recently used cards are evicted")
+	flag.DurationVar(&optCacheTTL, "cache-ttl", 0, "how long cached cards stay valid, 0 keeps them until evicted")
+	flag.StringVar(&optMemcached, "memcached", "", "comma separated memcached servers to cache rendered cards in, behind -cache-dir if set")
+	flag.Int64Var(&optMemoryCacheMax, "memory-cache-max-bytes", 0, "keep up to this many bytes of rendered cards in memory, in front of -cache-dir if set")
+	flag.IntVar(&optMaxInputLength, "max-input-length", 256, "longest id, name or address accepted from src/addresses.yml")
+	flag.BoolVar(&optFoldCase, "fold-case", false, "fold ids and names to lower case before validating and rendering them, leaving addresses as they are")
+	flag.IntVar(&optMaxPixels, "max-pixels", 16384, "largest width or height of a rasterized card, including supersampling")
+	flag.DurationVar(&optRenderTimeout, "render-timeout", 0, "give up on a card when drawing and encoding it takes longer than this, 0 to disable")
+	flag.StringVar(&optBadgeShape, "badge-shape", "circle", "shape of the backdrop behind the logo, circle, squircle, polygon or path")
+	flag.Float64Var(&optSquircleExponent, "squircle-exponent", 5, "superellipse exponent of the squircle badge, 2 is a circle and larger is squarer")
+	flag.Float64Var(&optSquircleRadius, "squircle-radius", 0, "corner radius of the squircle badge as a fraction of the badge radius, drawing a rounded square instead of a superellipse when above 0")
+	flag.Float64Var(&optCornerSmoothing, "squircle-smoothing", 0.6, "how far the corners of -squircle-radius ease into the sides, 0 for circular corners to 1, 0.6 is close to iOS icons")
+	flag.IntVar(&optPolygonSides, "polygon-sides", 6, "number of sides of the polygon badge")
+	flag.Float64Var(&optPolygonRotation, "polygon-rotation", 0, "rotation of the polygon badge in degrees, counterclockwise from a vertex at the top")
+	flag.Float64Var(&optPolygonRounding, "polygon-rounding", 0, "how far corners of the polygon badge are rounded off along each side, in millimetres")
+	flag.StringVar(&optBadgePath, "badge-path", "", "SVG path data of the path badge, scaled to fit the badge")
+	flag.StringVar(&optWatermark, "watermark", "", "PNG image composited over a corner of every card")
+	flag.StringVar(&optWatermarkCorner, "watermark-corner", "bottom-right", "corner of the watermark, top-left, top-right, bottom-left or bottom-right")
+	flag.Float64Var(&optWatermarkWidth, "watermark-width", 80, "width of the watermark in millimetres")
+	flag.Float64Var(&optWatermarkMargin, "watermark-margin", 30, "distance of the watermark from the card edges in millimetres")
+	flag.Float64Var(&optWatermarkOpacity, "watermark-opacity", 0.5, "opacity of the watermark, 0 to 1")
+	flag.BoolVar(&optShadow, "shadow", false, "draw a soft drop shadow under the logo backdrop and the name")
+	flag.BoolVar(&optInnerShadow, "inner-shadow", false, "shade the inside edge of the logo backdrop, using the -shadow-* settings")
+	flag.Float64Var(&optShadowOffset, "shadow-offset", 3, "offset of the drop and inner shadows in millimetres")
+	flag.Float64Var(&optShadowBlur, "shadow-blur", 4, "blur radius of the drop and inner shadows in millimetres")
+	flag.Var(colorValue{&optShadowColor}, "shadow-color", "CSS color of the drop and inner shadows")
+	flag.Float64Var(&optShadowOpacity, "shadow-opacity", 0.35, "opacity of the drop and inner shadows, 0 to 1")
+	flag.Float64Var(&optGrain, "grain", 0, "opacity of a noise texture over the card background, 0 to disable")
+	flag.BoolVar(&optStats, "stats", false, "log path, glyph, timing and size statistics of every card")
+	flag.StringVar(&optLayout, "layout", "", "YAML file placing the artwork, badge, logo, border and text of cards")
+	flag.StringVar(&optSVGText, "svg-text", "font", "how svg cards carry text, font for selectable text in an embedded font, or paths for glyph outlines")
+	flag.BoolVar(&optSVGResponsive, "svg-responsive", false, "leave width and height out of svg cards so they scale to their container")
+	flag.BoolVar(&optDataURI, "datauri", false, "print a data URI of every generated card to stdout")
+	flag.BoolVar(&optFavicon, "favicon", false, "also generate .ico favicons and apple touch icons from logos")
+	flag.StringVar(&optAnimate, "animate", "", "also generate an animated card, format gif or apng")
+	flag.StringVar(&optAnimation, "animation", "ring", "animation to render, ring, ripple, or pulse to darken and restore -background")
+	flag.IntVar(&optFrames, "frames", 12, "number of frames in animated cards")
+	flag.DurationVar(&optDuration, "duration", 1200*time.Millisecond, "duration of one loop of animated cards")
 }
 
 func generate(id, name, address string) (err error) {
-	gray := color.RGBA{R: 51, G: 51, B: 51, A: 255}
+	id, name, address = normalizeItem(id, name, address)
 	log.Println(id, name, address)
 
-	var q *qrcode.QRCode
-	if q, err = qrcode.New(address, qrcode.High); err != nil {
+	if err = validateItem(id, name, address); err != nil {
 		return
 	}
-	q.ForegroundColor = gray
-	b := &bytes.Buffer{}
-	if err = q.Write(512, b); err != nil {
+	if optStats {
+		before := statsSnapshot()
+		defer func() {
+			log.Println("stats", id, statsSnapshot().sub(before))
+		}()
+	}
+
+	var c *canvas.Canvas
+	if err = os.MkdirAll(filepath.Join("dist"), 0755); err != nil {
 		return
 	}
+	for _, format := range strings.Split(optFormats, ",") {
+		if err = writeFile(filepath.Join("dist", id+"."+format), func(w io.Writer) (err error) {
+			if !optDataURI {
+				return renderCard(w, &c, id, name, address, format)
+			}
+			fmt.Printf("%s.%s ", id, format)
+			var enc io.WriteCloser
+			if enc, err = newDataURIWriter(os.Stdout, cardFormats[format].MIMEType); err != nil {
+				return
+			}
+			if err = renderCard(io.MultiWriter(w, enc), &c, id, name, address, format); err != nil {
+				return
+			}
+			if err = enc.Close(); err != nil {
+				return
+			}
+			_, err = fmt.Println()
+			return
+		}); err != nil {
+			return
+		}
+	}
+	if optFavicon {
+		if err = generateFavicon(id); err != nil {
+			return
+		}
+	}
+	if optAnimate != "" {
+		if c == nil {
+			if c, err = drawCard(id, name, address); err != nil {
+				return
+			}
+		}
+		if err = generateAnimation(id, name, address, c); err != nil {
+			return
+		}
+	}
+	return
+}
 
-	buf := b.Bytes()
+// renderCard writes the card in format to w, from the cache when it has it,
+// otherwise drawing it into *c unless an earlier format already did. With a
+// cache, concurrent renders of the same card wait for the first one instead
+// of drawing it again.
+func renderCard(w io.Writer, c **canvas.Canvas, id, name, address, format string) (err error) {
+	draw := func() (*canvas.Canvas, error) {
+		return drawCard(id, name, address)
+	}
+	if cardCache == nil {
+		return writeCard(w, c, draw, format)
+	}
 
-	var img image.Image
-	if img, err = png.Decode(bytes.NewReader(buf)); err != nil {
+	var key string
+	if key, err = cardCacheKey(id, name, address, format); err != nil {
 		return
 	}
-
-	if buf, err = ioutil.ReadFile(filepath.Join("src", "logos", id+"-logo.png")); err != nil {
+	if cached, _ := cardCache.Get(key); cached != nil {
+		addStats(renderStats{CacheHits: 1})
+		_, err = w.Write(cached)
 		return
 	}
+	var v interface{}
+	if v, err, _ = renderFlights.Do(key, func() (interface{}, error) {
+		if cached, _ := cardCache.Get(key); cached != nil {
+			addStats(renderStats{CacheHits: 1})
+			return cached, nil
+		}
+		addStats(renderStats{CacheMisses: 1})
+		buf := &bytes.Buffer{}
+		if err := writeCard(buf, c, draw, format); err != nil {
+			return nil, err
+		}
+		// the card rendered, so a cache that cannot take it only costs a
+		// render next time
+		if err := cardCache.Put(key, buf.Bytes(), optCacheTTL); err != nil {
+			log.Println("warning: caching", id, "failed:", err)
+		}
+		return buf.Bytes(), nil
+	}); err != nil {
+		return
+	}
+	_, err = w.Write(v.([]byte))
+	return
+}
+
+func drawCard(id, name, address string) (c *canvas.Canvas, err error) {
 	var logo image.Image
-	if logo, err = png.Decode(bytes.NewReader(buf)); err != nil {
+	if logo, err = loadLogo(id); err != nil {
+		return
+	}
+	start := time.Now()
+	if c, err = composeCard(logo, name, address); err != nil {
 		return
 	}
+	s := renderStats{Cards: 1, Draw: time.Since(start)}
+	if optStats {
+		s.add(countCanvas(c))
+	}
+	addStats(s)
+	return
+}
 
+func composeCard(logo image.Image, name, address string) (c *canvas.Canvas, err error) {
+	return composeCardOn(logo, name, address, optBackground)
+}
+
+// composeCardOn draws the card over background instead of -background.
+func composeCardOn(logo image.Image, name, address string, background color.RGBA) (c *canvas.Canvas, err error) {
 	logoW, _ := float64(logo.Bounds().Max.X), float64(logo.Bounds().Max.Y)
 
-	logoSize := float64(64)
+	logoSize := layout.Logo.Size
 
-	c := canvas.New(600, 800)
+	w, h := layout.Width, layout.Height
+	c = canvas.New(w, h)
 	ctx := canvas.NewContext(c)
+	if background.A > 0 {
+		ctx.SetFillColor(background)
+		bgLine := &canvas.Polyline{}
+		bgLine.Add(w, 0).Add(w, h).Add(0, h).Add(0, 0)
+		ctx.DrawPath(0, 0, bgLine.ToPath())
+	}
+	if err = drawPattern(ctx, optPattern, address, w, h, false); err != nil {
+		return
+	}
+	if optGrain > 0 {
+		drawGrain(ctx, address, w, h, optGrain)
+	}
+	if err = drawArtwork(ctx, optStyle, address, layout.Artwork.X, layout.Artwork.Y, layout.Artwork.Size); err != nil {
+		return
+	}
+	badge := layout.Badge
+	var bgShape *canvas.Path
+	if bgShape, err = badgeShape(badge.Radius); err != nil {
+		return
+	}
+	if optShadow {
+		drawShadow(ctx, w, h, func(ctx *canvas.Context, col color.Color) {
+			ctx.SetFillColor(col)
+			ctx.DrawPath(badge.X, badge.Y, bgShape)
+			ctx.DrawText(layout.Text.X, layout.Text.Y, cardText(name, col))
+		})
+	}
 	ctx.SetFillColor(color.White)
-	bgLine := &canvas.Polyline{}
-	bgLine.Add(600, 0).Add(600, 800).Add(0, 800).Add(0, 0)
-	ctx.DrawPath(0, 0, bgLine.ToPath())
-	ctx.DrawImage((600.0-512.0)/2.0, (800.0-512.0)-((600.0-512.0)/2.0), img, 1)
-	bgCircle := canvas.Circle(50)
-	ctx.DrawPath(297, 500, bgCircle)
-	ctx.DrawImage(265, 469, logo, logoW/logoSize)
+	ctx.DrawPath(badge.X, badge.Y, bgShape)
+	if optInnerShadow {
+		drawInnerShadow(ctx, w, h, func(ctx *canvas.Context) {
+			ctx.DrawPath(badge.X, badge.Y, bgShape)
+		})
+	}
+	ctx.DrawImage(layout.Logo.X, layout.Logo.Y, logo, logoW/logoSize)
 	ctx.SetFillColor(gray)
+	inset := layout.Border.Inset
 	borderLine := &canvas.Polyline{}
-	borderLine.Add(0, 0).Add(560, 0).Add(560, 760).Add(0, 760).Add(0, 0)
-	ctx.DrawPath(20, 20, borderLine.ToPath().Stroke(4.0, canvas.RoundCap, canvas.ArcsJoin))
+	borderLine.Add(0, 0).Add(w-2*inset, 0).Add(w-2*inset, h-2*inset).Add(0, h-2*inset).Add(0, 0)
+	ctx.DrawPath(inset, inset, borderLine.ToPath().Stroke(layout.Border.Width, canvas.RoundCap, canvas.ArcsJoin))
 
-	headerFace := fontFamily.Face(128.0, gray, canvas.FontRegular, canvas.FontNormal)
-	tb := canvas.NewTextBox(headerFace, name, 600, 200, canvas.Center, canvas.Center, 0.0, 0.0)
-	ctx.DrawText(0, 250, tb)
+	ctx.DrawText(layout.Text.X, layout.Text.Y, cardText(name, gray))
+	if watermark != nil {
+		drawWatermark(ctx, watermark, optWatermarkCorner, w, h, optWatermarkWidth, optWatermarkMargin)
+	}
+	return
+}
 
-	if err = os.MkdirAll(filepath.Join("dist"), 0755); err != nil {
+func cardText(name string, col color.Color) *canvas.Text {
+	headerFace := fontFamily.Face(layout.Text.FontSize, col, textFace.Style, canvas.FontNormal)
+	headerFace.FauxBold = textFace.FauxBold * headerFace.Size * headerFace.Scale
+	headerFace.FauxItalic = textFace.FauxItalic
+	if headerFace.FauxBold != 0 && optFauxBold > 0 {
+		headerFace.FauxBold = optFauxBold * headerFace.Size * headerFace.Scale
+	}
+	if optFauxItalic > 0 && (headerFace.FauxItalic != 0 || headerFace.Font.ItalicAngle() != 0) {
+		// shears add up, so an italic face is sheared by the difference
+		// between its own slant and the one asked for
+		shear := math.Tan(optFauxItalic * math.Pi / 180)
+		if headerFace.FauxItalic == 0 {
+			shear -= math.Tan(-headerFace.Font.ItalicAngle() * math.Pi / 180)
+		}
+		headerFace.FauxItalic = shear
+	}
+	return canvas.NewTextBox(headerFace, name, layout.Text.Width, layout.Text.Height, textAligns[layout.Text.Align], textAligns[layout.Text.VAlign], 0.0, 0.0)
+}
+
+func loadLogo(id string) (logo image.Image, err error) {
+	return loadPNG(filepath.Join("src", "logos", id+"-logo.png"))
+}
+
+func loadPNG(name string) (img image.Image, err error) {
+	var f *os.File
+	if f, err = os.Open(name); err != nil {
 		return
 	}
-	if err = c.WriteFile(filepath.Join("dist", id+".png"), rasterizer.PNGWriter(1)); err != nil {
+	defer f.Close()
+	img, err = png.Decode(bufio.NewReader(f))
+	return
+}
+
+func scaleImage(img image.Image, size int) image.Image {
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	resize(dst, img, xdraw.Over)
+	return dst
+}
+
+func generateFavicon(id string) (err error) {
+	var logo image.Image
+	if logo, err = loadLogo(id); err != nil {
 		return
 	}
+
+	var icons []image.Image
+	for _, size := range faviconSizes {
+		icons = append(icons, scaleImage(logo, size))
+	}
+
+	if err = writeFile(filepath.Join("dist", id+".ico"), func(w io.Writer) error {
+		return encodeICO(w, icons)
+	}); err != nil {
+		return
+	}
+
+	for _, size := range touchIconSizes {
+		icon := scaleImage(logo, size)
+		if err = writeFile(filepath.Join("dist", fmt.Sprintf("%s-apple-touch-icon-%dx%d.png", id, size, size)), func(w io.Writer) error {
+			return pngEncoder.Encode(w, icon)
+		}); err != nil {
+			return
+		}
+	}
 	return
 }
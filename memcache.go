@@ -0,0 +1,227 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/bradfitz/gomemcache/memcache"
+	"hash/crc32"
+	"math"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memcacheRingReplicas is the number of points each server gets on the ring,
+// enough to spread keys evenly over a handful of servers.
+const memcacheRingReplicas = 160
+
+// memcacheRing picks servers by consistent hashing, so that adding or removing
+// a server only moves the keys next to its points on the ring.
+type memcacheRing struct {
+	points []uint32
+	addrs  map[uint32]net.Addr
+	all    []net.Addr
+}
+
+func newMemcacheRing(servers []string) (r *memcacheRing, err error) {
+	r = &memcacheRing{addrs: map[uint32]net.Addr{}}
+	for _, server := range servers {
+		var addr net.Addr
+		if strings.Contains(server, "/") {
+			addr, err = net.ResolveUnixAddr("unix", server)
+		} else {
+			addr, err = net.ResolveTCPAddr("tcp", server)
+		}
+		if err != nil {
+			return
+		}
+		r.all = append(r.all, addr)
+		for i := 0; i < memcacheRingReplicas; i++ {
+			point := crc32.ChecksumIEEE([]byte(fmt.Sprintf("%s-%d", server, i)))
+			if _, ok := r.addrs[point]; !ok {
+				r.addrs[point] = addr
+				r.points = append(r.points, point)
+			}
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	return
+}
+
+func (r *memcacheRing) PickServer(key string) (net.Addr, error) {
+	if len(r.points) == 0 {
+		return nil, memcache.ErrNoServers
+	}
+	h := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.addrs[r.points[i]], nil
+}
+
+func (r *memcacheRing) Each(f func(net.Addr) error) error {
+	for _, addr := range r.all {
+		if err := f(addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// memcachedCache keeps rendered cards in memcached, spread over the servers
+// with a memcacheRing. Memcached cannot list its keys, so the stored keys
+// include generation counters that Invalidate bumps instead of deleting the
+// cards, which then age out of memcached. Generations are kept locally for
+// memcacheGenerationTTL, so other processes see an invalidation that late.
+type memcachedCache struct {
+	client *memcache.Client
+
+	mu          sync.Mutex
+	generations map[string]memcacheGeneration
+}
+
+type memcacheGeneration struct {
+	value   string
+	fetched time.Time
+}
+
+const memcacheGenerationPrefix = "persona-generation"
+
+const memcacheGenerationTTL = 5 * time.Second
+
+func newMemcachedCache(servers []string) (m *memcachedCache, err error) {
+	var ring *memcacheRing
+	if ring, err = newMemcacheRing(servers); err != nil {
+		return
+	}
+	m = &memcachedCache{client: memcache.NewFromSelector(ring), generations: map[string]memcacheGeneration{}}
+	return
+}
+
+func (m *memcachedCache) generationKey(id string) string {
+	if id == "" {
+		return memcacheGenerationPrefix
+	}
+	return memcacheGenerationPrefix + cardCacheKeySeparator + id
+}
+
+// generationValues returns the counters of keys, from memcached for those not
+// fetched within memcacheGenerationTTL.
+func (m *memcachedCache) generationValues(keys ...string) (values []string, err error) {
+	now := time.Now()
+	values = make([]string, len(keys))
+	var stale []string
+	m.mu.Lock()
+	for i, key := range keys {
+		if g, ok := m.generations[key]; ok && now.Sub(g.fetched) < memcacheGenerationTTL {
+			values[i] = g.value
+		} else {
+			stale = append(stale, key)
+		}
+	}
+	m.mu.Unlock()
+	if len(stale) == 0 {
+		return
+	}
+
+	var items map[string]*memcache.Item
+	if items, err = m.client.GetMulti(stale); err != nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, key := range keys {
+		if values[i] != "" {
+			continue
+		}
+		values[i] = "0"
+		if item, ok := items[key]; ok {
+			values[i] = string(item.Value)
+		}
+		m.generations[key] = memcacheGeneration{value: values[i], fetched: now}
+	}
+	return
+}
+
+// storedKey prefixes key with the generations of all cards and of its id,
+// hashing the result when it is longer than memcached allows.
+func (m *memcachedCache) storedKey(key string) (stored string, err error) {
+	id := key[:strings.Index(key, cardCacheKeySeparator)]
+	var generations []string
+	if generations, err = m.generationValues(m.generationKey(""), m.generationKey(id)); err != nil {
+		return
+	}
+	stored = generations[0] + "." + generations[1] + "." + key
+	if len(stored) > 250 {
+		sum := sha256.Sum256([]byte(stored))
+		stored = hex.EncodeToString(sum[:])
+	}
+	return
+}
+
+func (m *memcachedCache) Get(key string) (data []byte, ok bool) {
+	stored, err := m.storedKey(key)
+	if err != nil {
+		return
+	}
+	item, err := m.client.Get(stored)
+	if err != nil {
+		return
+	}
+	return item.Value, true
+}
+
+func (m *memcachedCache) Put(key string, data []byte, ttl time.Duration) (err error) {
+	var stored string
+	if stored, err = m.storedKey(key); err != nil {
+		return
+	}
+	item := &memcache.Item{Key: stored, Value: data}
+	if ttl > 0 {
+		// memcached reads expirations past 30 days as unix timestamps
+		if seconds := int64(math.Ceil(ttl.Seconds())); seconds <= 30*24*60*60 {
+			item.Expiration = int32(seconds)
+		} else {
+			item.Expiration = int32(time.Now().Add(ttl).Unix())
+		}
+	}
+	return m.client.Set(item)
+}
+
+func (m *memcachedCache) Delete(key string) (err error) {
+	var stored string
+	if stored, err = m.storedKey(key); err != nil {
+		return
+	}
+	if err = m.client.Delete(stored); err == memcache.ErrCacheMiss {
+		err = nil
+	}
+	return
+}
+
+func (m *memcachedCache) Invalidate(id string) (err error) {
+	key := m.generationKey(id)
+	value := "1"
+	for {
+		var n uint64
+		if n, err = m.client.Increment(key, 1); err == nil {
+			value = fmt.Sprint(n)
+			break
+		} else if err != memcache.ErrCacheMiss {
+			return
+		}
+		if err = m.client.Add(&memcache.Item{Key: key, Value: []byte(value)}); err == nil {
+			break
+		} else if err != memcache.ErrNotStored {
+			return
+		}
+	}
+	m.mu.Lock()
+	m.generations[key] = memcacheGeneration{value: value, fetched: time.Now()}
+	m.mu.Unlock()
+	return
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"golang.org/x/text/unicode/norm"
+	"strings"
+	"unicode"
+)
+
+// normalizeInput composes s to NFC, drops control characters and collapses
+// runs of whitespace to single spaces.
+func normalizeInput(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) && !unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, norm.NFC.String(s))
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// normalizeItem normalizes the id, name and address of a card, and with
+// -fold-case folds the id and name to lower case, so that "Bitcoin" and
+// "BITCOIN" give the same card. Addresses keep their case, which carries a
+// checksum in some of them.
+func normalizeItem(id, name, address string) (string, string, string) {
+	id, name, address = normalizeInput(id), normalizeInput(name), normalizeInput(address)
+	if optFoldCase {
+		id, name = strings.ToLower(id), strings.ToLower(name)
+	}
+	return id, name, address
+}
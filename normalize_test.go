@@ -0,0 +1,42 @@
+package main
+
+import "testing"
+
+func TestNormalizeItem(t *testing.T) {
+	setupTestOptions(t)
+	defer func(fold bool) { optFoldCase = fold }(optFoldCase)
+	const address = "1BoatSLRHtKNngkdXEeobR76b53LETtpyT"
+	tests := []struct {
+		fold                bool
+		id, name            string
+		wantID, wantName    string
+		wantValidationError bool
+	}{
+		{false, "bitcoin-btc", "Jose\u0301 \t Coin\x00", "bitcoin-btc", "José Coin", false},
+		{false, "Bitcoin-BTC", "Bitcoin", "Bitcoin-BTC", "Bitcoin", true},
+		{true, "Bitcoin-BTC", "BITCOIN", "bitcoin-btc", "bitcoin", false},
+		{true, "bitcoin-btc", "JOSÉ", "bitcoin-btc", "josé", false},
+	}
+	for _, tt := range tests {
+		optFoldCase = tt.fold
+		id, name, gotAddress := normalizeItem(tt.id, tt.name, " "+address+"\n")
+		if id != tt.wantID || name != tt.wantName || gotAddress != address {
+			t.Errorf("normalizeItem(%q, %q) with -fold-case=%v = %q, %q, %q, want %q, %q, %q", tt.id, tt.name, tt.fold, id, name, gotAddress, tt.wantID, tt.wantName, address)
+		}
+		if err := validateItem(id, name, gotAddress); (err != nil) != tt.wantValidationError {
+			t.Errorf("validateItem(%q, %q) = %v, want error %v", id, name, err, tt.wantValidationError)
+		}
+	}
+
+	// folded inputs share their cards with inputs already in lower case
+	optFoldCase = false
+	want, err := cardCacheKey("bitcoin-btc", "bitcoin", address, "png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	optFoldCase = true
+	id, name, _ := normalizeItem("Bitcoin-BTC", "Bitcoin", address)
+	if got, err := cardCacheKey(id, name, address, "png"); err != nil || got != want {
+		t.Errorf("cache key of folded input = %q, %v, want %q", got, err, want)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"github.com/tdewolff/canvas"
+	"github.com/tdewolff/canvas/rasterizer"
+	"math"
+)
+
+// patterns are the names drawPattern accepts.
+var patterns = map[string]bool{"none": true, "stripes": true, "dots": true, "chevrons": true, "split": true}
+
+// drawPattern draws pattern over a w by h area, seeded from seed. With tile it
+// first fills the area with the pattern's own background, otherwise whatever
+// is below, such as -background, shows between the shapes. split paints both
+// its tones either way, since it has no gaps for anything to show through.
+func drawPattern(ctx *canvas.Context, pattern, seed string, w, h float64, tile bool) (err error) {
+	if pattern == "none" {
+		return
+	}
+	sum := sha256.Sum256([]byte(seed))
+	cell := 16 + float64(sum[0]%24)
+	angle := float64(sum[1]) / 256 * 180
+	hue := float64(sum[2]) / 256 * 360
+
+	d := math.Hypot(w, h) / 2
+	p := &canvas.Path{}
+	var back *canvas.Path
+	bg, fg := hsl(hue, 0.5, 0.95), hsl(hue, 0.5, 0.88)
+	switch pattern {
+	case "stripes":
+		for x := -d; x < d; x += cell {
+			p = p.Append(canvas.Rectangle(cell/2, 2*d).Translate(x, -d))
+		}
+	case "dots":
+		for x := -d; x < d; x += cell {
+			for y := -d; y < d; y += cell {
+				p = p.Append(canvas.Circle(cell/4).Translate(x, y))
+			}
+		}
+	case "chevrons":
+		for y := -d; y < d; y += cell {
+			line := &canvas.Polyline{}
+			for i, x := 0, -d; x < d+cell; i, x = i+1, x+cell/2 {
+				line.Add(x, y+float64(i%2)*cell/2)
+			}
+			p = p.Append(line.ToPath().Stroke(cell/5, canvas.ButtCap, canvas.MiterJoin))
+		}
+	case "split":
+		p = canvas.Rectangle(d, 2*d).Translate(0, -d)
+		back = canvas.Rectangle(2*d, 2*d).Translate(-d, -d)
+		angle = 0
+		if sum[3]%2 == 1 {
+			angle = math.Atan2(h, w)*180/math.Pi - 90
+		}
+		bg = hsl(hue, 0.5, 0.9)
+		fg = rotateHue(bg, 180)
+	default:
+		return fmt.Errorf("unknown pattern: %s", pattern)
+	}
+
+	ctx.Push()
+	defer ctx.Pop()
+	if tile {
+		ctx.SetFillColor(bg)
+		ctx.DrawPath(0, 0, canvas.Rectangle(w, h))
+	}
+	ctx.RotateAbout(angle, w/2, h/2)
+	if back != nil {
+		ctx.SetFillColor(bg)
+		ctx.DrawPath(w/2, h/2, back)
+	}
+	ctx.SetFillColor(fg)
+	ctx.DrawPath(w/2, h/2, p)
+	return
+}
+
+func drawPatternArtwork(ctx *canvas.Context, pattern, seed string, x, y, size float64) (err error) {
+	if pattern == "none" {
+		pattern = "stripes"
+	}
+	c := canvas.New(size, size)
+	if err = drawPattern(canvas.NewContext(c), pattern, seed, size, size, true); err != nil {
+		return
+	}
+	resolution := canvas.DPMM(optResolution * float64(optSupersample))
+	ctx.DrawImage(x, y, rasterizer.Draw(c, resolution), float64(resolution))
+	return
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"github.com/tdewolff/canvas"
+	"testing"
+)
+
+// TestSplitPatternOpaque checks that the split pattern paints both of its
+// tones over a card, leaving nothing of -background to show through.
+func TestSplitPatternOpaque(t *testing.T) {
+	for _, seed := range []string{"a", "b", "c", "d"} {
+		c := canvas.New(40, 30)
+		if err := drawPattern(canvas.NewContext(c), "split", seed, 40, 30, false); err != nil {
+			t.Fatal(err)
+		}
+		img := rasterize(c, 1, 1)
+		tones := map[[4]uint8]bool{}
+		for i := 0; i < len(img.Pix); i += 4 {
+			if img.Pix[i+3] != 255 {
+				x, y := i%img.Stride/4, i/img.Stride
+				t.Fatalf("seed %q: pixel %d,%d has alpha %d", seed, x, y, img.Pix[i+3])
+			}
+			tones[[4]uint8{img.Pix[i], img.Pix[i+1], img.Pix[i+2], 255}] = true
+		}
+		if len(tones) < 2 {
+			t.Errorf("seed %q: %d tones, want both halves", seed, len(tones))
+		}
+		putRGBA(img)
+	}
+}
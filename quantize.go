@@ -0,0 +1,116 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"sort"
+)
+
+type colorCount struct {
+	c [4]uint8
+	n int
+}
+
+// colorBox is a run of the histogram that median cut splits along the channel
+// with the widest range.
+type colorBox []colorCount
+
+func (b colorBox) widest() (ch int, width int) {
+	for i := 0; i < 4; i++ {
+		lo, hi := uint8(255), uint8(0)
+		for _, cc := range b {
+			if cc.c[i] < lo {
+				lo = cc.c[i]
+			}
+			if cc.c[i] > hi {
+				hi = cc.c[i]
+			}
+		}
+		if w := int(hi) - int(lo); w > width {
+			ch, width = i, w
+		}
+	}
+	return
+}
+
+func (b colorBox) average() color.RGBA {
+	var sum [4]int
+	total := 0
+	for _, cc := range b {
+		for i := range sum {
+			sum[i] += int(cc.c[i]) * cc.n
+		}
+		total += cc.n
+	}
+	return color.RGBA{
+		R: uint8((sum[0] + total/2) / total),
+		G: uint8((sum[1] + total/2) / total),
+		B: uint8((sum[2] + total/2) / total),
+		A: uint8((sum[3] + total/2) / total),
+	}
+}
+
+// quantize reduces img to at most n colours by median cut. Images that already
+// use n colours or fewer keep them exactly, otherwise the error is diffused
+// with Floyd-Steinberg when dither is set.
+func quantize(img *image.RGBA, n int, dither bool) *image.Paletted {
+	counts := map[[4]uint8]int{}
+	for i := 0; i < len(img.Pix); i += 4 {
+		counts[[4]uint8{img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3]}]++
+	}
+	hist := make(colorBox, 0, len(counts))
+	for c, count := range counts {
+		hist = append(hist, colorCount{c, count})
+	}
+	sort.Slice(hist, func(i, j int) bool {
+		a, b := hist[i].c, hist[j].c
+		for k := range a {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return false
+	})
+
+	boxes := []colorBox{hist}
+	for len(boxes) < n {
+		best, bestWidth, bestCh := -1, 0, 0
+		for i, b := range boxes {
+			if len(b) < 2 {
+				continue
+			}
+			if ch, width := b.widest(); width > bestWidth {
+				best, bestWidth, bestCh = i, width, ch
+			}
+		}
+		if best < 0 {
+			break
+		}
+		b := boxes[best]
+		sort.SliceStable(b, func(i, j int) bool { return b[i].c[bestCh] < b[j].c[bestCh] })
+		total := 0
+		for _, cc := range b {
+			total += cc.n
+		}
+		split, acc := 1, b[0].n
+		for split < len(b)-1 && acc < total/2 {
+			acc += b[split].n
+			split++
+		}
+		boxes[best] = b[:split]
+		boxes = append(boxes, b[split:])
+	}
+
+	palette := make(color.Palette, len(boxes))
+	for i, b := range boxes {
+		palette[i] = b.average()
+	}
+	dst := image.NewPaletted(img.Bounds(), palette)
+	if dither && len(hist) > n {
+		draw.FloydSteinberg.Draw(dst, dst.Bounds(), img, img.Bounds().Min)
+	} else {
+		draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Src)
+	}
+	return dst
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestQuantize(t *testing.T) {
+	red, green, blue := color.RGBA{R: 255, A: 255}, color.RGBA{G: 255, A: 255}, color.RGBA{B: 128, A: 128}
+	stripes := func(colors ...color.RGBA) *image.RGBA {
+		img := image.NewRGBA(image.Rect(0, 0, len(colors), 4))
+		for x, c := range colors {
+			for y := 0; y < 4; y++ {
+				img.SetRGBA(x, y, c)
+			}
+		}
+		return img
+	}
+	var grays []color.RGBA
+	for i := 0; i < 256; i++ {
+		grays = append(grays, color.RGBA{R: uint8(i), G: uint8(i), B: uint8(i), A: 255})
+	}
+	tests := []struct {
+		name     string
+		img      *image.RGBA
+		n        int
+		dither   bool
+		colors   int
+		maxError int
+		palette  color.Palette
+	}{
+		{"fewer colours", stripes(red, green, blue), 4, false, 3, 0, nil},
+		{"exact colours", stripes(red, green, blue), 3, true, 3, 0, nil},
+		{"one colour", stripes(red, red, blue), 1, false, 1, 170, color.Palette{color.RGBA{R: 170, B: 43, A: 213}}},
+		{"gray ramp", stripes(grays...), 16, false, 16, 8, nil},
+		{"dithered gray ramp", stripes(grays...), 16, true, 16, 16, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := quantize(tt.img, tt.n, tt.dither)
+			if len(p.Palette) != tt.colors {
+				t.Fatalf("quantize to %d colours has %d, want %d", tt.n, len(p.Palette), tt.colors)
+			}
+			for i, c := range tt.palette {
+				if p.Palette[i] != c {
+					t.Errorf("palette[%d] = %v, want %v", i, p.Palette[i], c)
+				}
+			}
+			if p.Bounds() != tt.img.Bounds() {
+				t.Fatalf("bounds = %v, want %v", p.Bounds(), tt.img.Bounds())
+			}
+			worst := 0
+			for y := tt.img.Rect.Min.Y; y < tt.img.Rect.Max.Y; y++ {
+				for x := tt.img.Rect.Min.X; x < tt.img.Rect.Max.X; x++ {
+					want := tt.img.RGBAAt(x, y)
+					got := color.RGBAModel.Convert(p.At(x, y)).(color.RGBA)
+					for _, d := range []int{
+						int(got.R) - int(want.R), int(got.G) - int(want.G),
+						int(got.B) - int(want.B), int(got.A) - int(want.A),
+					} {
+						if d < 0 {
+							d = -d
+						}
+						if d > worst {
+							worst = d
+						}
+					}
+				}
+			}
+			if worst > tt.maxError {
+				t.Errorf("largest channel error = %d, want at most %d", worst, tt.maxError)
+			}
+		})
+	}
+}
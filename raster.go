@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"github.com/tdewolff/canvas"
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/math/f64"
+	"golang.org/x/image/vector"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"sync"
+	"time"
+)
+
+var rgbaPool = sync.Pool{}
+
+func getRGBA(r image.Rectangle) *image.RGBA {
+	if img, ok := rgbaPool.Get().(*image.RGBA); ok && cap(img.Pix) >= 4*r.Dx()*r.Dy() {
+		img.Pix = img.Pix[:4*r.Dx()*r.Dy()]
+		for i := range img.Pix {
+			img.Pix[i] = 0
+		}
+		img.Stride = 4 * r.Dx()
+		img.Rect = r
+		return img
+	}
+	return image.NewRGBA(r)
+}
+
+func putRGBA(img *image.RGBA) {
+	rgbaPool.Put(img)
+}
+
+type pngBufferPool struct {
+	sync.Pool
+}
+
+func (p *pngBufferPool) Get() *png.EncoderBuffer {
+	b, _ := p.Pool.Get().(*png.EncoderBuffer)
+	return b
+}
+
+func (p *pngBufferPool) Put(b *png.EncoderBuffer) {
+	p.Pool.Put(b)
+}
+
+var pngEncoder = &png.Encoder{BufferPool: &pngBufferPool{}}
+
+var pngCompressionLevels = map[string]png.CompressionLevel{
+	"default": png.DefaultCompression,
+	"none":    png.NoCompression,
+	"speed":   png.BestSpeed,
+	"best":    png.BestCompression,
+}
+
+// rasterTileRows is the height of the tiles paths and images are drawn in.
+// The tiles do not depend on -parallelism, which only deals them out to the
+// bands, so a card comes out the same however many bands rasterize it.
+const rasterTileRows = 64
+
+// bandRenderer draws the rows of band into img, one tile at a time. It lays
+// out every path and image against the whole of img, but only rasterizes the
+// tiles of the band they cover. Paths come out as rasterizer.Renderer draws
+// them; images are not stretched by the margin it pads them with.
+type bandRenderer struct {
+	img        *image.RGBA
+	band       image.Rectangle
+	resolution canvas.DPMM
+	padded     *paddedImages
+}
+
+// paddedImages holds the images of a card padded with a transparent margin,
+// so the bands pad each image only once between them.
+type paddedImages struct {
+	mu     sync.Mutex
+	images map[image.Image]*paddedImage
+}
+
+type paddedImage struct {
+	once sync.Once
+	img  *image.RGBA
+}
+
+// get returns img padded with margin on every side.
+func (p *paddedImages) get(img image.Image, margin int) *image.RGBA {
+	p.mu.Lock()
+	if p.images == nil {
+		p.images = map[image.Image]*paddedImage{}
+	}
+	padded, ok := p.images[img]
+	if !ok {
+		padded = &paddedImage{}
+		p.images[img] = padded
+	}
+	p.mu.Unlock()
+	padded.once.Do(func() {
+		size := img.Bounds().Size()
+		padded.img = image.NewRGBA(image.Rect(0, 0, size.X+2*margin, size.Y+2*margin))
+		draw.Draw(padded.img, image.Rect(margin, margin, size.X+margin, size.Y+margin), img, img.Bounds().Min, draw.Over)
+	})
+	return padded.img
+}
+
+func (r bandRenderer) Size() (float64, float64) {
+	size := r.img.Bounds().Size()
+	return float64(size.X) / float64(r.resolution), float64(size.Y) / float64(r.resolution)
+}
+
+// tiles calls fn with every tile of rect that lies in the band.
+func (r bandRenderer) tiles(rect image.Rectangle, fn func(tile image.Rectangle)) {
+	rect = rect.Intersect(r.band)
+	for y := rect.Min.Y / rasterTileRows * rasterTileRows; y < rect.Max.Y; y += rasterTileRows {
+		fn(image.Rect(rect.Min.X, y, rect.Max.X, y+rasterTileRows).Intersect(rect))
+	}
+}
+
+// fill rasterizes path, shifted left by rect.Min.X, into the tiles of rect.
+func (r bandRenderer) fill(path *canvas.Path, rect image.Rectangle, col color.RGBA) {
+	bottom := r.img.Bounds().Max.Y
+	resolution := float64(r.resolution)
+	src := image.NewUniform(col)
+	ras := &vector.Rasterizer{}
+	r.tiles(rect, func(tile image.Rectangle) {
+		ras.Reset(tile.Dx(), tile.Dy())
+		path.Translate(0, -float64(bottom-tile.Max.Y)/resolution).ToRasterizer(ras, resolution)
+		ras.Draw(r.img, tile, src, image.Point{})
+	})
+}
+
+func (r bandRenderer) RenderPath(path *canvas.Path, style canvas.Style, m canvas.Matrix) {
+	path = path.Transform(m)
+
+	strokeWidth := 0.0
+	if style.StrokeColor.A != 0 && 0.0 < style.StrokeWidth {
+		strokeWidth = style.StrokeWidth
+	}
+
+	size := r.img.Bounds().Size()
+	bounds := path.Bounds()
+	resolution := float64(r.resolution)
+	x := int((bounds.X - strokeWidth) * resolution)
+	y := int((bounds.Y - strokeWidth) * resolution)
+	w := int((bounds.W+2*strokeWidth)*resolution) + 1
+	h := int((bounds.H+2*strokeWidth)*resolution) + 1
+	if (x+w <= 0 || size.X <= x) && (y+h <= 0 || size.Y <= y) {
+		return
+	}
+	if x < 0 {
+		x = 0
+	}
+	if y < 0 {
+		y = 0
+	}
+	if size.X <= x+w {
+		w = size.X - x
+	}
+	if size.Y <= y+h {
+		h = size.Y - y
+	}
+	if w <= 0 || h <= 0 {
+		return
+	}
+	rect := image.Rect(x, size.Y-y-h, x+w, size.Y-y)
+	if !rect.Overlaps(r.band) {
+		return
+	}
+
+	path = path.Translate(-float64(x)/resolution, 0)
+	if style.FillColor.A != 0 {
+		r.fill(path, rect, style.FillColor)
+	}
+	if strokeWidth != 0.0 {
+		if 0 < len(style.Dashes) {
+			path = path.Dash(style.DashOffset, style.Dashes...)
+		}
+		path = path.Stroke(style.StrokeWidth, style.StrokeCapper, style.StrokeJoiner)
+		r.fill(path, rect, style.StrokeColor)
+	}
+}
+
+func (r bandRenderer) RenderText(text *canvas.Text, m canvas.Matrix) {
+	renderTextAsPath(r, text, m)
+}
+
+func (r bandRenderer) RenderImage(img image.Image, m canvas.Matrix) {
+	// A transparent margin keeps the edges smooth when img is rotated.
+	const margin = 4
+	size := img.Bounds().Size()
+	src := r.padded.get(img, margin)
+
+	// m maps the pixels of img to millimetres, so src is laid out one pixel
+	// to one pixel of img, shifted by the margin.
+	origin := m.Dot(canvas.Point{X: -float64(margin), Y: float64(size.Y + margin)}).Mul(float64(r.resolution))
+	m = m.Scale(float64(r.resolution), float64(r.resolution))
+	h := float64(r.img.Bounds().Size().Y)
+	aff3 := f64.Aff3{m[0][0], -m[0][1], origin.X, -m[1][0], m[1][1], h - origin.Y}
+
+	r.tiles(transformBounds(aff3, src.Bounds()), func(tile image.Rectangle) {
+		xdraw.CatmullRom.Transform(r.img.SubImage(tile).(*image.RGBA), aff3, src, src.Bounds(), xdraw.Over, nil)
+	})
+}
+
+// transformBounds returns a rectangle holding r mapped through aff3, with a
+// pixel to spare on every side.
+func transformBounds(aff3 f64.Aff3, r image.Rectangle) image.Rectangle {
+	x0, y0, x1, y1 := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+	for _, p := range []image.Point{r.Min, {X: r.Max.X, Y: r.Min.Y}, {X: r.Min.X, Y: r.Max.Y}, r.Max} {
+		x := aff3[0]*float64(p.X) + aff3[1]*float64(p.Y) + aff3[2]
+		y := aff3[3]*float64(p.X) + aff3[4]*float64(p.Y) + aff3[5]
+		x0, y0, x1, y1 = math.Min(x0, x), math.Min(y0, y), math.Max(x1, x), math.Max(y1, y)
+	}
+	return image.Rect(int(math.Floor(x0))-2, int(math.Floor(y0))-2, int(math.Ceil(x1))+2, int(math.Ceil(y1))+2)
+}
+
+func rasterize(c *canvas.Canvas, resolution canvas.DPMM, parallelism int) *image.RGBA {
+	img, _ := rasterizeContext(context.Background(), c, resolution, parallelism)
+	return img
+}
+
+// rasterizeContext renders c in parallelism horizontal bands of whole tiles,
+// and gives up before starting a band once ctx is done.
+func rasterizeContext(ctx context.Context, c *canvas.Canvas, resolution canvas.DPMM, parallelism int) (*image.RGBA, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	img := getRGBA(image.Rect(0, 0, int(c.W*float64(resolution)+0.5), int(c.H*float64(resolution)+0.5)))
+	height := img.Bounds().Dy()
+	tiles := (height + rasterTileRows - 1) / rasterTileRows
+	if parallelism > tiles {
+		parallelism = tiles
+	}
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	padded := &paddedImages{}
+	wg := &sync.WaitGroup{}
+	for i := 0; i < parallelism; i++ {
+		y0, y1 := rasterTileRows*(tiles*i/parallelism), rasterTileRows*(tiles*(i+1)/parallelism)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ctx.Err() != nil {
+				return
+			}
+			c.Render(bandRenderer{
+				img:        img,
+				band:       image.Rect(0, y0, img.Bounds().Dx(), y1),
+				resolution: resolution,
+				padded:     padded,
+			})
+		}()
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		putRGBA(img)
+		return nil, err
+	}
+	return img, nil
+}
+
+func rasterizeCard(ctx context.Context, c *canvas.Canvas) (img *image.RGBA, err error) {
+	start := time.Now()
+	defer func() {
+		addStats(renderStats{Rasterize: time.Since(start)})
+	}()
+	resolution := canvas.DPMM(optResolution)
+	if optSupersample <= 1 {
+		return rasterizeContext(ctx, c, resolution, optParallelism)
+	}
+	var src *image.RGBA
+	if src, err = rasterizeContext(ctx, c, resolution*canvas.DPMM(optSupersample), optParallelism); err != nil {
+		return
+	}
+	defer putRGBA(src)
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	img = getRGBA(image.Rect(0, 0, int(c.W*optResolution+0.5), int(c.H*optResolution+0.5)))
+	resize(img, src, xdraw.Src)
+	return
+}
+
+func pngWriter(ctx context.Context, w io.Writer, c *canvas.Canvas) error {
+	img, err := rasterizeCard(ctx, c)
+	if err != nil {
+		return err
+	}
+	defer putRGBA(img)
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	if optPNGColors > 0 {
+		return pngEncoder.Encode(w, quantize(img, optPNGColors, optPNGDither))
+	}
+	return pngEncoder.Encode(w, img)
+}
+
+func jpegWriter(ctx context.Context, w io.Writer, c *canvas.Canvas) error {
+	img, err := rasterizeCard(ctx, c)
+	if err != nil {
+		return err
+	}
+	defer putRGBA(img)
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	flat := getRGBA(img.Bounds())
+	defer putRGBA(flat)
+	draw.Draw(flat, flat.Bounds(), image.NewUniform(color.RGBA{R: optMatte.R, G: optMatte.G, B: optMatte.B, A: 255}), image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), img, image.Point{}, draw.Over)
+	return jpeg.Encode(w, flat, &jpeg.Options{Quality: optJPEGQuality})
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/tdewolff/canvas"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// TestRasterizeBands checks that rasterizing a card in bands gives the same
+// pixels as rasterizing it in one go, since the card cache ignores
+// -parallelism.
+func TestRasterizeBands(t *testing.T) {
+	setupTestOptions(t)
+	logo, err := loadLogo("bitcoin-btc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(style string) { optStyle = style }(optStyle)
+	for _, style := range []string{"qrcode", "ring", "blockies"} {
+		optStyle = style
+		c, err := composeCard(logo, "Bitcoin", "1BoatSLRHtKNngkdXEeobR76b53LETtpyT")
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := rasterize(c, 1, 1)
+		for _, parallelism := range []int{2, 3, 7} {
+			got := rasterize(c, 1, parallelism)
+			if !bytes.Equal(got.Pix, want.Pix) {
+				t.Errorf("%s: %d bands differ from one", style, parallelism)
+			}
+			putRGBA(got)
+		}
+		putRGBA(want)
+	}
+}
+
+// BenchmarkRasterizeBands rasterizes a card in one band and in several. The
+// bands split the work between them, so the time per card should fall with
+// the number of cores, and never grow with the number of bands.
+func BenchmarkRasterizeBands(b *testing.B) {
+	setupTestOptions(b)
+	logo, err := loadLogo("bitcoin-btc")
+	if err != nil {
+		b.Fatal(err)
+	}
+	c, err := composeCard(logo, "Bitcoin", "1BoatSLRHtKNngkdXEeobR76b53LETtpyT")
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, parallelism := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprint(parallelism), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				putRGBA(rasterize(c, 2, parallelism))
+			}
+		})
+	}
+}
+
+// TestRasterizeImage checks that an image drawn at the rasterizing resolution
+// on whole pixels comes out pixel for pixel.
+func TestRasterizeImage(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 6, 4))
+	for i := range src.Pix {
+		src.Pix[i] = uint8(i * 7)
+	}
+	for i := 3; i < len(src.Pix); i += 4 {
+		src.Pix[i] = 255
+	}
+	c := canvas.New(20, 10)
+	canvas.NewContext(c).DrawImage(5, 3, src, 2)
+	for _, parallelism := range []int{1, 3} {
+		img := rasterize(c, 2, parallelism)
+		// The image sits 10 pixels from the left and 6 from the bottom.
+		at := image.Rect(10, 20-6-4, 16, 20-6)
+		for y := 0; y < img.Bounds().Dy(); y++ {
+			for x := 0; x < img.Bounds().Dx(); x++ {
+				var want color.RGBA
+				if p := image.Pt(x, y); p.In(at) {
+					want = src.RGBAAt(x-at.Min.X, y-at.Min.Y)
+				}
+				if got := img.RGBAAt(x, y); got != want {
+					t.Fatalf("%d bands: pixel %d,%d = %v, want %v", parallelism, x, y, got, want)
+				}
+			}
+		}
+		putRGBA(img)
+	}
+}
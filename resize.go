@@ -0,0 +1,34 @@
+package main
+
+import (
+	xdraw "golang.org/x/image/draw"
+	"image"
+	"math"
+)
+
+// lanczos is the Lanczos-3 windowed sinc kernel, sharper than Catmull-Rom at
+// the cost of some ringing next to hard edges.
+var lanczos = &xdraw.Kernel{Support: 3, At: func(t float64) float64 {
+	if t == 0 {
+		return 1
+	}
+	if t >= 3 {
+		return 0
+	}
+	x := math.Pi * t
+	return 3 * math.Sin(x) * math.Sin(x/3) / (x * x)
+}}
+
+var resamplers = map[string]xdraw.Interpolator{
+	"nearest":     xdraw.NearestNeighbor,
+	"bilinear":    xdraw.BiLinear,
+	"catmull-rom": xdraw.CatmullRom,
+	"lanczos":     lanczos,
+}
+
+// resize scales src to fill dst with the -resample kernel. The kernels filter
+// premultiplied colours, so transparent pixels do not bleed their colour into
+// antialiased edges.
+func resize(dst *image.RGBA, src image.Image, op xdraw.Op) {
+	resamplers[optResample].Scale(dst, dst.Bounds(), src, src.Bounds(), op, nil)
+}
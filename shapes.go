@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"github.com/tdewolff/canvas"
+	"math"
+)
+
+func annularSector(r0, r1, theta0, theta1 float64) *canvas.Path {
+	sin0, cos0 := math.Sincos(theta0 * math.Pi / 180.0)
+	sin1, cos1 := math.Sincos(theta1 * math.Pi / 180.0)
+	p := &canvas.Path{}
+	p.MoveTo(r1*cos0, r1*sin0)
+	p.Arc(r1, r1, 0, theta0, theta1)
+	p.LineTo(r0*cos1, r0*sin1)
+	p.Arc(r0, r0, 0, theta1, theta0)
+	p.Close()
+	return p
+}
+
+// superellipse approximates |x/r|^n + |y/r|^n = 1, a circle for n = 2 and a
+// squircle close to iOS icons for n around 5.
+func superellipse(r, n float64) *canvas.Path {
+	const steps = 256
+	p := &canvas.Path{}
+	for i := 0; i < steps; i++ {
+		sin, cos := math.Sincos(2 * math.Pi * float64(i) / steps)
+		x := r * math.Copysign(math.Pow(math.Abs(cos), 2/n), cos)
+		y := r * math.Copysign(math.Pow(math.Abs(sin), 2/n), sin)
+		if i == 0 {
+			p.MoveTo(x, y)
+		} else {
+			p.LineTo(x, y)
+		}
+	}
+	p.Close()
+	return p
+}
+
+// smoothRoundedSquare is a square of size 2r with corners of radius
+// radius*r, where smoothing from 0 to 1 eases each corner into its sides with
+// curves along up to twice the radius, like the corner smoothing of Figma and
+// the continuous rounded rectangles of Material. It is a plain rounded square
+// for smoothing 0.
+func smoothRoundedSquare(r, radius, smoothing float64) *canvas.Path {
+	cr := r * math.Min(radius, 1)
+	if cr > 0 {
+		smoothing = math.Min(smoothing, r/cr-1)
+	}
+	span := (1 + smoothing) * cr
+	arc := math.Pi / 2 * (1 - smoothing)
+	arcSide := math.Sin(arc/2) * cr * math.Sqrt2
+	alpha := (math.Pi/2 - arc) / 2
+	beta := math.Pi / 4 * smoothing
+	c := cr * math.Tan(alpha/2) * math.Cos(beta)
+	d := c * math.Tan(beta)
+	b := (span - arcSide - c - d) / 3
+	a := 2 * b
+
+	// The top right corner runs clockwise from the top side to the right side,
+	// and the other corners are the same turned by quarters.
+	turn := func(i int, x, y float64) (float64, float64) {
+		for ; i > 0; i-- {
+			x, y = y, -x
+		}
+		return x, y
+	}
+	p := &canvas.Path{}
+	for i := 0; i < 4; i++ {
+		x0, y0 := r-span, r
+		x1, y1 := x0+a+b+c, y0-d
+		x2, y2 := x1+arcSide, y1-arcSide
+		if i == 0 {
+			p.MoveTo(turn(i, x0, y0))
+		} else {
+			p.LineTo(turn(i, x0, y0))
+		}
+		c1x, c1y := turn(i, x0+a, y0)
+		c2x, c2y := turn(i, x0+a+b, y0)
+		ex, ey := turn(i, x1, y1)
+		p.CubeTo(c1x, c1y, c2x, c2y, ex, ey)
+		ex, ey = turn(i, x2, y2)
+		p.ArcTo(cr, cr, 0, false, false, ex, ey)
+		c1x, c1y = turn(i, x2+d, y2-c)
+		c2x, c2y = turn(i, x2+d, y2-b-c)
+		ex, ey = turn(i, r, r-span)
+		p.CubeTo(c1x, c1y, c2x, c2y, ex, ey)
+	}
+	p.Close()
+	return p
+}
+
+// polygon is a regular polygon with a vertex at the top before rotating by
+// rotation degrees, with corners rounded off by up to rounding along each side.
+// The corners are offset with the products wrapped in float64, so that FMA
+// does not move them on arm64 or GOAMD64=v3.
+func polygon(r float64, sides int, rotation, rounding float64) *canvas.Path {
+	vertices := make([]canvas.Point, sides)
+	for i := range vertices {
+		sin, cos := math.Sincos((90 + rotation + 360*float64(i)/float64(sides)) * math.Pi / 180)
+		vertices[i] = canvas.Point{X: r * cos, Y: r * sin}
+	}
+	side := 2 * r * math.Sin(math.Pi/float64(sides))
+	t := math.Min(rounding/side, 0.5)
+
+	p := &canvas.Path{}
+	for i, v := range vertices {
+		prev, next := vertices[(i+sides-1)%sides], vertices[(i+1)%sides]
+		a := canvas.Point{X: v.X + float64((prev.X-v.X)*t), Y: v.Y + float64((prev.Y-v.Y)*t)}
+		b := canvas.Point{X: v.X + float64((next.X-v.X)*t), Y: v.Y + float64((next.Y-v.Y)*t)}
+		if i == 0 {
+			p.MoveTo(a.X, a.Y)
+		} else {
+			p.LineTo(a.X, a.Y)
+		}
+		if t > 0 {
+			p.QuadTo(v.X, v.Y, b.X, b.Y)
+		}
+	}
+	p.Close()
+	return p
+}
+
+// svgShape parses an SVG path d attribute and fits it into a square of size
+// 2r centred on the origin, flipped to the y-up coordinates of canvas.
+func svgShape(r float64, d string) (*canvas.Path, error) {
+	p, err := canvas.ParseSVG(d)
+	if err != nil {
+		return nil, err
+	}
+	b := p.Bounds()
+	if b.W <= 0 && b.H <= 0 {
+		return nil, fmt.Errorf("path is empty")
+	}
+	scale := 2 * r / math.Max(b.W, b.H)
+	return p.Transform(canvas.Identity.Scale(scale, -scale).Translate(-b.X-b.W/2, -b.Y-b.H/2)), nil
+}
+
+func badgeShape(r float64) (*canvas.Path, error) {
+	switch optBadgeShape {
+	case "circle":
+		return canvas.Circle(r), nil
+	case "squircle":
+		if optSquircleRadius > 0 {
+			return smoothRoundedSquare(r, optSquircleRadius, optCornerSmoothing), nil
+		}
+		return superellipse(r, optSquircleExponent), nil
+	case "polygon":
+		return polygon(r, optPolygonSides, optPolygonRotation, optPolygonRounding), nil
+	case "path":
+		return svgShape(r, optBadgePath)
+	}
+	return nil, fmt.Errorf("unknown badge shape: %s", optBadgeShape)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/tdewolff/canvas"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const soakAlphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+type soakSample struct {
+	Heap uint64
+	FDs  int
+}
+
+func takeSoakSample() soakSample {
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	fds := -1
+	if entries, err := ioutil.ReadDir("/proc/self/fd"); err == nil {
+		fds = len(entries)
+	}
+	return soakSample{Heap: m.HeapAlloc, FDs: fds}
+}
+
+func randomSoakString(rnd *rand.Rand, min, max int) string {
+	b := make([]byte, min+rnd.Intn(max-min+1))
+	for i := range b {
+		b[i] = soakAlphabet[rnd.Intn(len(soakAlphabet))]
+	}
+	return string(b)
+}
+
+func soak(args []string) (err error) {
+	fset := flag.NewFlagSet("soak", flag.ExitOnError)
+	qps := fset.Float64("qps", 10, "renders started per second")
+	duration := fset.Duration("duration", time.Minute, "how long to keep rendering")
+	workers := fset.Int("workers", runtime.NumCPU(), "number of concurrent renders")
+	interval := fset.Duration("report", 10*time.Second, "interval between heap and fd reports")
+	maxGrowth := fset.Float64("max-heap-growth", 0.5, "fail if the heap after GC grows by more than this fraction since the first report")
+	seed := fset.Int64("seed", 1, "seed for randomized inputs")
+	cached := fset.Bool("cache", false, "render through the caches set up by -cache-dir, -memcached and -memory-cache-max-bytes instead of bypassing them")
+	if err = fset.Parse(args); err != nil {
+		return
+	}
+	if *qps <= 0 || *workers < 1 {
+		return errors.New("soak: qps and workers must be positive")
+	}
+
+	var logos []string
+	if logos, err = filepath.Glob(filepath.Join("src", "logos", "*-logo.png")); err != nil {
+		return
+	}
+	if len(logos) == 0 {
+		return errors.New("soak: no logos found")
+	}
+	for i, logo := range logos {
+		logos[i] = strings.TrimSuffix(filepath.Base(logo), "-logo.png")
+	}
+
+	if !*cached {
+		defer func(c Cache) { cardCache = c }(cardCache)
+		cardCache = nil
+	}
+	formats := strings.Split(optFormats, ",")
+
+	var rendered, failed, dropped int64
+	jobs := make(chan [3]string)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < *workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				var c *canvas.Canvas
+				var err error
+				for _, format := range formats {
+					if err = renderCard(ioutil.Discard, &c, job[0], job[1], job[2], format); err != nil {
+						break
+					}
+				}
+				if err != nil {
+					log.Println("soak:", job[0], err)
+					atomic.AddInt64(&failed, 1)
+					continue
+				}
+				atomic.AddInt64(&rendered, 1)
+			}
+		}()
+	}
+
+	rnd := rand.New(rand.NewSource(*seed))
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / *qps))
+	report := time.NewTicker(*interval)
+	deadline := time.After(*duration)
+
+	var first *soakSample
+	var last soakSample
+loop:
+	for {
+		select {
+		case <-ticker.C:
+			job := [3]string{
+				logos[rnd.Intn(len(logos))],
+				randomSoakString(rnd, 3, 12) + " (" + strings.ToUpper(randomSoakString(rnd, 3, 4)) + ")",
+				randomSoakString(rnd, 26, 95),
+			}
+			select {
+			case jobs <- job:
+			default:
+				atomic.AddInt64(&dropped, 1)
+			}
+		case <-report.C:
+			last = takeSoakSample()
+			if first == nil {
+				sample := last
+				first = &sample
+			}
+			log.Printf("soak: rendered=%d failed=%d dropped=%d heap=%d fds=%d", atomic.LoadInt64(&rendered), atomic.LoadInt64(&failed), atomic.LoadInt64(&dropped), last.Heap, last.FDs)
+		case <-deadline:
+			break loop
+		}
+	}
+	ticker.Stop()
+	report.Stop()
+	close(jobs)
+	wg.Wait()
+
+	last = takeSoakSample()
+	log.Printf("soak: done rendered=%d failed=%d dropped=%d heap=%d fds=%d", rendered, failed, dropped, last.Heap, last.FDs)
+	if first == nil {
+		return
+	}
+	if growth := float64(last.Heap)/float64(first.Heap) - 1; growth > *maxGrowth {
+		return fmt.Errorf("soak: heap grew %.0f%% from %d to %d bytes", growth*100, first.Heap, last.Heap)
+	}
+	if last.FDs > first.FDs {
+		return fmt.Errorf("soak: open file descriptors grew from %d to %d", first.FDs, last.FDs)
+	}
+	return
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"github.com/tdewolff/canvas"
+	"image"
+	"io"
+	"sync"
+	"time"
+)
+
+// renderStats counts the work done to render cards. Encode includes the time
+// spent in Rasterize for raster formats. CacheHits counts the cards served from
+// the card cache and CacheMisses the cards rendered for it; a render waiting
+// for another render of the same card counts as neither. CacheEvictions counts
+// the entries the memory and disk caches dropped to stay under their size.
+// CacheBytes is not a count but the bytes the memory and disk caches hold when
+// the stats are taken.
+type renderStats struct {
+	Cards          int
+	Paths          int
+	Segments       int
+	Glyphs         int
+	Images         int
+	Files          int
+	Bytes          int64
+	Draw           time.Duration
+	Rasterize      time.Duration
+	Encode         time.Duration
+	CacheHits      int
+	CacheMisses    int
+	CacheEvictions int
+	CacheBytes     int64
+}
+
+func (s *renderStats) add(o renderStats) {
+	s.Cards += o.Cards
+	s.Paths += o.Paths
+	s.Segments += o.Segments
+	s.Glyphs += o.Glyphs
+	s.Images += o.Images
+	s.Files += o.Files
+	s.Bytes += o.Bytes
+	s.Draw += o.Draw
+	s.Rasterize += o.Rasterize
+	s.Encode += o.Encode
+	s.CacheHits += o.CacheHits
+	s.CacheMisses += o.CacheMisses
+	s.CacheEvictions += o.CacheEvictions
+}
+
+func (s renderStats) sub(o renderStats) renderStats {
+	return renderStats{
+		Cards:          s.Cards - o.Cards,
+		Paths:          s.Paths - o.Paths,
+		Segments:       s.Segments - o.Segments,
+		Glyphs:         s.Glyphs - o.Glyphs,
+		Images:         s.Images - o.Images,
+		Files:          s.Files - o.Files,
+		Bytes:          s.Bytes - o.Bytes,
+		Draw:           s.Draw - o.Draw,
+		Rasterize:      s.Rasterize - o.Rasterize,
+		Encode:         s.Encode - o.Encode,
+		CacheHits:      s.CacheHits - o.CacheHits,
+		CacheMisses:    s.CacheMisses - o.CacheMisses,
+		CacheEvictions: s.CacheEvictions - o.CacheEvictions,
+		CacheBytes:     s.CacheBytes,
+	}
+}
+
+func (s renderStats) String() string {
+	return fmt.Sprintf("cards=%d paths=%d segments=%d glyphs=%d images=%d files=%d bytes=%d draw=%s rasterize=%s encode=%s cache-hits=%d cache-misses=%d cache-evictions=%d cache-bytes=%d",
+		s.Cards, s.Paths, s.Segments, s.Glyphs, s.Images, s.Files, s.Bytes,
+		s.Draw.Round(time.Microsecond), s.Rasterize.Round(time.Microsecond), s.Encode.Round(time.Microsecond),
+		s.CacheHits, s.CacheMisses, s.CacheEvictions, s.CacheBytes)
+}
+
+var (
+	statsMu sync.Mutex
+	stats   renderStats
+)
+
+func addStats(s renderStats) {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	stats.add(s)
+}
+
+func statsSnapshot() renderStats {
+	// the caches add evictions under their own locks, so they are not asked
+	// for their size under statsMu
+	size := cacheSize()
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	s := stats
+	s.CacheBytes = size
+	return s
+}
+
+// statsRenderer counts what a canvas draws without rendering it.
+type statsRenderer struct {
+	w, h  float64
+	stats *renderStats
+}
+
+func (r statsRenderer) Size() (float64, float64) {
+	return r.w, r.h
+}
+
+func (r statsRenderer) RenderPath(path *canvas.Path, style canvas.Style, m canvas.Matrix) {
+	r.stats.Paths++
+	segment := func(p0, p1 canvas.Point) {
+		r.stats.Segments++
+	}
+	path.Iterate(
+		segment,
+		segment,
+		func(p0, p1, p2 canvas.Point) { segment(p0, p2) },
+		func(p0, p1, p2, p3 canvas.Point) { segment(p0, p3) },
+		func(p0 canvas.Point, rx, ry, phi float64, large, sweep bool, p1 canvas.Point) { segment(p0, p1) },
+		segment,
+	)
+}
+
+func (r statsRenderer) RenderText(text *canvas.Text, m canvas.Matrix) {
+	text.WalkSpans(func(y, dx float64, span canvas.TextSpan) {
+		r.stats.Glyphs += span.CountGlyphs()
+	})
+}
+
+func (r statsRenderer) RenderImage(img image.Image, m canvas.Matrix) {
+	r.stats.Images++
+}
+
+func countCanvas(c *canvas.Canvas) (s renderStats) {
+	w, h := c.Size()
+	c.Render(statsRenderer{w: w, h: h, stats: &s})
+	return
+}
+
+type countingWriter struct {
+	io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (n int, err error) {
+	n, err = w.Writer.Write(p)
+	w.n += int64(n)
+	return
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"github.com/skip2/go-qrcode"
+	"github.com/tdewolff/canvas"
+	"sort"
+)
+
+// Style draws the artwork of a card into the square at x, y with the given size.
+type Style interface {
+	Draw(ctx *canvas.Context, address string, x, y, size float64) error
+}
+
+type StyleFunc func(ctx *canvas.Context, address string, x, y, size float64) error
+
+func (f StyleFunc) Draw(ctx *canvas.Context, address string, x, y, size float64) error {
+	return f(ctx, address, x, y, size)
+}
+
+var styles = map[string]Style{}
+
+func registerStyle(name string, style Style) {
+	if _, ok := styles[name]; ok {
+		panic("style already registered: " + name)
+	}
+	styles[name] = style
+}
+
+func styleNames() (names []string) {
+	for name := range styles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+func init() {
+	registerStyle("qrcode", StyleFunc(drawQRCode))
+	registerStyle("blockies", StyleFunc(drawBlockies))
+	registerStyle("ring", StyleFunc(drawRing))
+	registerStyle("pattern", StyleFunc(func(ctx *canvas.Context, address string, x, y, size float64) error {
+		return drawPatternArtwork(ctx, optPattern, address, x, y, size)
+	}))
+}
+
+func drawArtwork(ctx *canvas.Context, style, address string, x, y, size float64) error {
+	s, ok := styles[style]
+	if !ok {
+		return fmt.Errorf("unknown style: %s", style)
+	}
+	ctx.Push()
+	defer ctx.Pop()
+	return s.Draw(ctx, address, x, y, size)
+}
+
+func drawQRCode(ctx *canvas.Context, address string, x, y, size float64) (err error) {
+	var q *qrcode.QRCode
+	if q, err = qrcode.New(address, qrcode.High); err != nil {
+		return
+	}
+	q.ForegroundColor = gray
+	ctx.DrawImage(x, y, q.Image(512), 512/size)
+	return
+}
+
+// drawBlockies fills the background and then one rectangle per foreground
+// and spot cell, as ethereum-blockies draws onto its canvas, so the cells stay
+// crisp at any resolution and are paths in svg and pdf cards.
+func drawBlockies(ctx *canvas.Context, address string, x, y, size float64) error {
+	palette, grid := blockies(address, 8)
+	cell := size / float64(len(grid))
+	ctx.SetFillColor(palette[0])
+	ctx.DrawPath(x, y, canvas.Rectangle(size, size))
+	for i := 1; i < len(palette); i++ {
+		p := &canvas.Path{}
+		for row, cells := range grid {
+			for col, c := range cells {
+				if int(c) == i {
+					p = p.Append(canvas.Rectangle(cell, cell).Translate(float64(col)*cell, size-float64(row+1)*cell))
+				}
+			}
+		}
+		ctx.SetFillColor(palette[i])
+		ctx.DrawPath(x, y, p)
+	}
+	return nil
+}
+
+// drawRing splits a ring into arcs sized and coloured from the hash of the
+// address. Products are wrapped in float64 conversions as in hsl.
+func drawRing(ctx *canvas.Context, address string, x, y, size float64) error {
+	h := sha256.Sum256([]byte(address))
+	cx, cy, r := x+size/2, y+size/2, size/2
+
+	count := 3 + int(h[0]%6)
+	gap := 4.0
+	total := 0.0
+	for i := 0; i < count; i++ {
+		total += float64(h[1+i]) + 32
+	}
+
+	palette, err := lookupPalette(optPalette)
+	if err != nil {
+		return err
+	}
+	theta := float64(h[10]) / 256 * 360
+	hue := float64(h[11]) / 256 * 360
+	for i := 0; i < count; i++ {
+		sweep := float64((float64(h[1+i]) + 32) / total * (360 - float64(gap*float64(count))))
+		ctx.SetFillColor(palette.Color(hue + 360*float64(i)/float64(count) + float64(h[12+i]%30)))
+		ctx.DrawPath(cx, cy, annularSector(r*0.45, r*0.9, theta, theta+sweep))
+		theta += sweep + gap
+	}
+	return nil
+}
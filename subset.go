@@ -0,0 +1,492 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+	"sort"
+)
+
+// subsetTables are the tables subsetSFNT keeps, next to the kern table it
+// writes. Everything else goes: the hinting tables, which only serve the
+// instructions stripped from the glyphs, and the layout tables such as GSUB
+// and GPOS, which refer to the original glyph IDs.
+var subsetTables = map[string]bool{
+	"OS/2": true,
+	"cmap": true,
+	"glyf": true,
+	"head": true,
+	"hhea": true,
+	"hmtx": true,
+	"loca": true,
+	"maxp": true,
+	"name": true,
+	"post": true,
+}
+
+type sfntTable struct {
+	Tag  string
+	Data []byte
+}
+
+func readSFNTTables(b []byte) (version uint32, tables map[string][]byte, err error) {
+	if len(b) < 12 {
+		err = errors.New("subset: truncated header")
+		return
+	}
+	version = binary.BigEndian.Uint32(b)
+	n := int(binary.BigEndian.Uint16(b[4:]))
+	if len(b) < 12+16*n {
+		err = errors.New("subset: truncated table directory")
+		return
+	}
+	tables = map[string][]byte{}
+	for i := 0; i < n; i++ {
+		entry := b[12+16*i:]
+		offset, length := binary.BigEndian.Uint32(entry[8:]), binary.BigEndian.Uint32(entry[12:])
+		if uint64(offset)+uint64(length) > uint64(len(b)) {
+			err = errors.New("subset: table out of bounds")
+			return
+		}
+		tables[string(entry[:4])] = b[offset : offset+length]
+	}
+	return
+}
+
+func sfntChecksum(b []byte) (sum uint32) {
+	for i := 0; i < len(b); i += 4 {
+		var word [4]byte
+		copy(word[:], b[i:])
+		sum += binary.BigEndian.Uint32(word[:])
+	}
+	return
+}
+
+func writeSFNT(version uint32, tables []sfntTable) []byte {
+	sort.Slice(tables, func(i, j int) bool { return tables[i].Tag < tables[j].Tag })
+
+	n := len(tables)
+	entrySelector := 0
+	for 1<<(entrySelector+1) <= n {
+		entrySelector++
+	}
+	searchRange := (1 << entrySelector) * 16
+
+	header := make([]byte, 12+16*n)
+	binary.BigEndian.PutUint32(header, version)
+	binary.BigEndian.PutUint16(header[4:], uint16(n))
+	binary.BigEndian.PutUint16(header[6:], uint16(searchRange))
+	binary.BigEndian.PutUint16(header[8:], uint16(entrySelector))
+	binary.BigEndian.PutUint16(header[10:], uint16(n*16-searchRange))
+
+	offset := len(header)
+	headOffset := -1
+	for i, table := range tables {
+		entry := header[12+16*i:]
+		copy(entry, table.Tag)
+		binary.BigEndian.PutUint32(entry[4:], sfntChecksum(table.Data))
+		binary.BigEndian.PutUint32(entry[8:], uint32(offset))
+		binary.BigEndian.PutUint32(entry[12:], uint32(len(table.Data)))
+		if table.Tag == "head" {
+			headOffset = offset
+		}
+		offset += (len(table.Data) + 3) &^ 3
+	}
+
+	out := make([]byte, 0, offset)
+	out = append(out, header...)
+	for _, table := range tables {
+		out = append(out, table.Data...)
+		for len(out)%4 != 0 {
+			out = append(out, 0)
+		}
+	}
+	if headOffset >= 0 {
+		binary.BigEndian.PutUint32(out[headOffset+8:], 0xB1B0AFBA-sfntChecksum(out))
+	}
+	return out
+}
+
+// walkComponents calls fn with the offset of each component record of a
+// composite glyph, and returns the offset just past the last one.
+func walkComponents(glyph []byte, fn func(p int)) (end int) {
+	if len(glyph) < 10 || int16(binary.BigEndian.Uint16(glyph)) >= 0 {
+		return
+	}
+	for p := 10; p+4 <= len(glyph); {
+		flags := binary.BigEndian.Uint16(glyph[p:])
+		fn(p)
+		p += 4
+		if flags&0x0001 != 0 {
+			p += 4
+		} else {
+			p += 2
+		}
+		switch {
+		case flags&0x0008 != 0:
+			p += 2
+		case flags&0x0040 != 0:
+			p += 4
+		case flags&0x0080 != 0:
+			p += 8
+		}
+		end = p
+		if flags&0x0020 == 0 {
+			break
+		}
+	}
+	return
+}
+
+func glyphComponents(glyph []byte) (components []uint16) {
+	walkComponents(glyph, func(p int) {
+		components = append(components, binary.BigEndian.Uint16(glyph[p+2:]))
+	})
+	return
+}
+
+// stripGlyphInstructions returns a copy of glyph without its TrueType hinting
+// instructions, which would otherwise call into the fpgm, prep and cvt tables
+// that subsetSFNT drops.
+func stripGlyphInstructions(glyph []byte) []byte {
+	if len(glyph) < 10 {
+		return glyph
+	}
+	if contours := int(int16(binary.BigEndian.Uint16(glyph))); contours >= 0 {
+		p := 10 + 2*contours
+		if p+2 > len(glyph) {
+			return glyph
+		}
+		n := int(binary.BigEndian.Uint16(glyph[p:]))
+		if p+2+n > len(glyph) {
+			return glyph
+		}
+		out := append([]byte{}, glyph[:p]...)
+		out = append(out, 0, 0)
+		return append(out, glyph[p+2+n:]...)
+	}
+	out := append([]byte{}, glyph...)
+	end := walkComponents(out, func(p int) {
+		flags := binary.BigEndian.Uint16(out[p:])
+		binary.BigEndian.PutUint16(out[p:], flags&^0x0100)
+	})
+	if end == 0 || end > len(out) {
+		return glyph
+	}
+	return out[:end]
+}
+
+// subsetCmap writes a cmap table mapping runes to glyphs, with a format 4
+// subtable for the Basic Multilingual Plane and a format 12 one when there are
+// runes beyond it.
+func subsetCmap(cmap map[rune]uint16) []byte {
+	var runes []rune
+	for r := range cmap {
+		runes = append(runes, r)
+	}
+	sort.Slice(runes, func(i, j int) bool { return runes[i] < runes[j] })
+
+	// Runs of consecutive runes with consecutive glyphs share a segment.
+	type segment struct{ start, end rune }
+	var bmp, all []segment
+	for _, r := range runes {
+		if n := len(all); n > 0 && all[n-1].end+1 == r && cmap[r]-cmap[all[n-1].end] == uint16(r-all[n-1].end) {
+			all[n-1].end = r
+		} else {
+			all = append(all, segment{r, r})
+		}
+	}
+	for _, seg := range all {
+		if seg.start >= 0xFFFF {
+			break
+		}
+		if seg.end >= 0xFFFF {
+			seg.end = 0xFFFE
+		}
+		bmp = append(bmp, seg)
+	}
+	bmp = append(bmp, segment{0xFFFF, 0xFFFF})
+
+	n := len(bmp)
+	entrySelector := 0
+	for 1<<(entrySelector+1) <= n {
+		entrySelector++
+	}
+	searchRange := 2 << entrySelector
+	format4 := make([]byte, 16+8*n)
+	binary.BigEndian.PutUint16(format4, 4)
+	binary.BigEndian.PutUint16(format4[2:], uint16(len(format4)))
+	binary.BigEndian.PutUint16(format4[6:], uint16(2*n))
+	binary.BigEndian.PutUint16(format4[8:], uint16(searchRange))
+	binary.BigEndian.PutUint16(format4[10:], uint16(entrySelector))
+	binary.BigEndian.PutUint16(format4[12:], uint16(2*n-searchRange))
+	for i, seg := range bmp {
+		delta := uint16(1)
+		if seg.start != 0xFFFF {
+			delta = cmap[seg.start] - uint16(seg.start)
+		}
+		binary.BigEndian.PutUint16(format4[14+2*i:], uint16(seg.end))
+		binary.BigEndian.PutUint16(format4[16+2*n+2*i:], uint16(seg.start))
+		binary.BigEndian.PutUint16(format4[16+4*n+2*i:], delta)
+	}
+
+	subtables := [][]byte{format4}
+	if len(runes) > 0 && runes[len(runes)-1] > 0xFFFF {
+		format12 := make([]byte, 16+12*len(all))
+		binary.BigEndian.PutUint16(format12, 12)
+		binary.BigEndian.PutUint32(format12[4:], uint32(len(format12)))
+		binary.BigEndian.PutUint32(format12[12:], uint32(len(all)))
+		for i, seg := range all {
+			group := format12[16+12*i:]
+			binary.BigEndian.PutUint32(group, uint32(seg.start))
+			binary.BigEndian.PutUint32(group[4:], uint32(seg.end))
+			binary.BigEndian.PutUint32(group[8:], uint32(cmap[seg.start]))
+		}
+		subtables = append(subtables, format12)
+	}
+
+	out := make([]byte, 4+8*len(subtables))
+	binary.BigEndian.PutUint16(out[2:], uint16(len(subtables)))
+	for i, subtable := range subtables {
+		record := out[4+8*i:]
+		binary.BigEndian.PutUint16(record, 3)
+		binary.BigEndian.PutUint16(record[2:], []uint16{1, 10}[i])
+		binary.BigEndian.PutUint32(record[4:], uint32(len(out)))
+		out = append(out, subtable...)
+	}
+	return out
+}
+
+// subsetKern writes a kern table with the kerning between the kept glyphs as
+// sfnt reads it: from the pair adjustments of the GPOS kern feature when the
+// font has any, otherwise from its kern table. GPOS kerning that sfnt skips,
+// such as adjustments to more than the advance or outside latn and DFLT, is
+// lost. It returns nil when there is none.
+func subsetKern(f *sfnt.Font, oldIDs []uint16, unitsPerEm int) []byte {
+	buf := &sfnt.Buffer{}
+	var pairs []byte
+	for left, x0 := range oldIDs {
+		for right, x1 := range oldIDs {
+			kern, err := f.Kern(buf, sfnt.GlyphIndex(x0), sfnt.GlyphIndex(x1), fixed.Int26_6(unitsPerEm), font.HintingNone)
+			if err != nil || kern == 0 {
+				continue
+			}
+			var entry [6]byte
+			binary.BigEndian.PutUint16(entry[:], uint16(left))
+			binary.BigEndian.PutUint16(entry[2:], uint16(right))
+			binary.BigEndian.PutUint16(entry[4:], uint16(int16(kern)))
+			pairs = append(pairs, entry[:]...)
+		}
+	}
+	n := len(pairs) / 6
+	if n == 0 || 14+len(pairs) > 0xFFFF {
+		return nil
+	}
+
+	entrySelector := 0
+	for 1<<(entrySelector+1) <= n {
+		entrySelector++
+	}
+	searchRange := 6 << entrySelector
+	out := make([]byte, 18, 18+len(pairs))
+	binary.BigEndian.PutUint16(out[2:], 1)
+	binary.BigEndian.PutUint16(out[6:], uint16(14+len(pairs)))
+	out[9] = 0x01
+	binary.BigEndian.PutUint16(out[10:], uint16(n))
+	binary.BigEndian.PutUint16(out[12:], uint16(searchRange))
+	binary.BigEndian.PutUint16(out[14:], uint16(entrySelector))
+	binary.BigEndian.PutUint16(out[16:], uint16(6*n-searchRange))
+	return append(out, pairs...)
+}
+
+// subsetName keeps the copyright notice and the names of the font, IDs 0 to
+// 6, and drops the rest, such as the license text.
+func subsetName(name []byte) []byte {
+	if len(name) < 6 {
+		return name
+	}
+	count, storage := int(binary.BigEndian.Uint16(name[2:])), int(binary.BigEndian.Uint16(name[4:]))
+	var records [][]byte
+	var data []byte
+	for i := 0; i < count && 6+12*i+12 <= len(name); i++ {
+		record := append([]byte{}, name[6+12*i:6+12*i+12]...)
+		length, offset := int(binary.BigEndian.Uint16(record[8:])), int(binary.BigEndian.Uint16(record[10:]))
+		if binary.BigEndian.Uint16(record[6:]) > 6 || binary.BigEndian.Uint16(record[4:]) >= 0x8000 || storage+offset+length > len(name) {
+			continue
+		}
+		binary.BigEndian.PutUint16(record[10:], uint16(len(data)))
+		data = append(data, name[storage+offset:storage+offset+length]...)
+		records = append(records, record)
+	}
+	out := make([]byte, 6, 6+12*len(records)+len(data))
+	binary.BigEndian.PutUint16(out[2:], uint16(len(records)))
+	binary.BigEndian.PutUint16(out[4:], uint16(6+12*len(records)))
+	for _, record := range records {
+		out = append(out, record...)
+	}
+	return append(out, data...)
+}
+
+// subsetSFNT keeps the glyphs that cmap maps runes to, the glyphs they are
+// composed of and .notdef, renumbered in their original order. The cmap, hmtx,
+// loca and kern tables are rebuilt for the new glyph IDs, the tables that
+// still refer to the old ones are dropped, and so are all but the first few
+// names. Hinting is dropped too, instructions and all.
+func subsetSFNT(b []byte, cmap map[rune]uint16) (out []byte, err error) {
+	var version uint32
+	var tables map[string][]byte
+	if version, tables, err = readSFNTTables(b); err != nil {
+		return
+	}
+	var f *sfnt.Font
+	if f, err = sfnt.Parse(b); err != nil {
+		return
+	}
+	head, hhea, maxp := tables["head"], tables["hhea"], tables["maxp"]
+	hmtx, loca, glyf := tables["hmtx"], tables["loca"], tables["glyf"]
+	if glyf == nil || loca == nil || len(head) < 54 || len(hhea) < 36 || len(maxp) < 6 {
+		err = errors.New("subset: not a TrueType outline font")
+		return
+	}
+
+	numGlyphs := int(binary.BigEndian.Uint16(maxp[4:]))
+	longLoca := binary.BigEndian.Uint16(head[50:]) == 1
+	offsets := make([]uint32, numGlyphs+1)
+	for i := range offsets {
+		if longLoca && 4*i+4 <= len(loca) {
+			offsets[i] = binary.BigEndian.Uint32(loca[4*i:])
+		} else if !longLoca && 2*i+2 <= len(loca) {
+			offsets[i] = 2 * uint32(binary.BigEndian.Uint16(loca[2*i:]))
+		}
+	}
+	glyph := func(id uint16) []byte {
+		if int(id) >= numGlyphs {
+			return nil
+		}
+		start, end := offsets[id], offsets[id+1]
+		if start >= end || int(end) > len(glyf) {
+			return nil
+		}
+		return glyf[start:end]
+	}
+
+	keep := map[uint16]bool{}
+	queue := []uint16{0}
+	for _, id := range cmap {
+		queue = append(queue, id)
+	}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		if keep[id] || int(id) >= numGlyphs {
+			continue
+		}
+		keep[id] = true
+		queue = append(queue, glyphComponents(glyph(id))...)
+	}
+	var oldIDs []uint16
+	for id := range keep {
+		oldIDs = append(oldIDs, id)
+	}
+	sort.Slice(oldIDs, func(i, j int) bool { return oldIDs[i] < oldIDs[j] })
+	newIDs := map[uint16]uint16{}
+	for newID, id := range oldIDs {
+		newIDs[id] = uint16(newID)
+	}
+
+	numHMetrics := int(binary.BigEndian.Uint16(hhea[34:]))
+	metric := func(id int) (advance, lsb []byte) {
+		advance, lsb = []byte{0, 0}, []byte{0, 0}
+		if numHMetrics == 0 {
+			return
+		}
+		last := numHMetrics - 1
+		if id < numHMetrics {
+			last = id
+		}
+		if 4*last+4 <= len(hmtx) {
+			advance = hmtx[4*last : 4*last+2]
+		}
+		if id < numHMetrics && 4*id+4 <= len(hmtx) {
+			lsb = hmtx[4*id+2 : 4*id+4]
+		} else if p := 4*numHMetrics + 2*(id-numHMetrics); id >= numHMetrics && p+2 <= len(hmtx) {
+			lsb = hmtx[p : p+2]
+		}
+		return
+	}
+
+	newGlyf := []byte{}
+	newLoca := make([]byte, 4*(len(oldIDs)+1))
+	newHmtx := make([]byte, 0, 4*len(oldIDs))
+	for newID, id := range oldIDs {
+		binary.BigEndian.PutUint32(newLoca[4*newID:], uint32(len(newGlyf)))
+		data := stripGlyphInstructions(glyph(id))
+		if len(glyphComponents(data)) > 0 {
+			data = append([]byte{}, data...)
+			walkComponents(data, func(p int) {
+				binary.BigEndian.PutUint16(data[p+2:], newIDs[binary.BigEndian.Uint16(data[p+2:])])
+			})
+		}
+		newGlyf = append(newGlyf, data...)
+		for len(newGlyf)%4 != 0 {
+			newGlyf = append(newGlyf, 0)
+		}
+		advance, lsb := metric(int(id))
+		newHmtx = append(append(newHmtx, advance...), lsb...)
+	}
+	binary.BigEndian.PutUint32(newLoca[4*len(oldIDs):], uint32(len(newGlyf)))
+
+	newCmap := map[rune]uint16{}
+	for r, id := range cmap {
+		if newID, ok := newIDs[id]; ok {
+			newCmap[r] = newID
+		}
+	}
+
+	newMaxp := append([]byte{}, maxp...)
+	binary.BigEndian.PutUint16(newMaxp[4:], uint16(len(oldIDs)))
+	if len(newMaxp) >= 28 {
+		binary.BigEndian.PutUint16(newMaxp[26:], 0)
+	}
+
+	newHhea := append([]byte{}, hhea...)
+	binary.BigEndian.PutUint16(newHhea[34:], uint16(len(oldIDs)))
+
+	newHead := append([]byte{}, head...)
+	binary.BigEndian.PutUint32(newHead[8:], 0)
+	binary.BigEndian.PutUint16(newHead[50:], 1)
+
+	var result []sfntTable
+	for tag, data := range tables {
+		switch {
+		case !subsetTables[tag]:
+			continue
+		case tag == "head":
+			data = newHead
+		case tag == "hhea":
+			data = newHhea
+		case tag == "maxp":
+			data = newMaxp
+		case tag == "hmtx":
+			data = newHmtx
+		case tag == "loca":
+			data = newLoca
+		case tag == "glyf":
+			data = newGlyf
+		case tag == "cmap":
+			data = subsetCmap(newCmap)
+		case tag == "name":
+			data = subsetName(data)
+		case tag == "post" && len(data) >= 32:
+			data = append([]byte{0, 3, 0, 0}, data[4:32]...)
+		}
+		result = append(result, sfntTable{Tag: tag, Data: data})
+	}
+	if kern := subsetKern(f, oldIDs, int(binary.BigEndian.Uint16(head[18:]))); kern != nil {
+		result = append(result, sfntTable{Tag: "kern", Data: kern})
+	}
+	out = writeSFNT(version, result)
+	return
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	canvasFont "github.com/tdewolff/canvas/font"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestSubsetSFNT(t *testing.T) {
+	custom, err := ioutil.ReadFile(filepath.Join("src", "custom-font.ttf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		ttf  []byte
+	}{
+		{"goregular", goregular.TTF},
+		{"custom-font", custom},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testSubsetSFNT(t, tt.ttf)
+		})
+	}
+}
+
+func testSubsetSFNT(t *testing.T, ttf []byte) {
+	orig, err := sfnt.Parse(ttf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &sfnt.Buffer{}
+	index := func(f *sfnt.Font, r rune) sfnt.GlyphIndex {
+		id, err := f.GlyphIndex(buf, r)
+		if err != nil {
+			t.Fatalf("no glyph for %q: %v", r, err)
+		}
+		return id
+	}
+	outline := func(f *sfnt.Font, id sfnt.GlyphIndex) string {
+		segments, err := f.LoadGlyph(buf, id, fixed.I(64), nil)
+		if err != nil {
+			t.Fatalf("glyph %d: %v", id, err)
+		}
+		return fmt.Sprint(segments)
+	}
+	advance := func(f *sfnt.Font, id sfnt.GlyphIndex) fixed.Int26_6 {
+		adv, err := f.GlyphAdvance(buf, id, fixed.I(64), font.HintingNone)
+		if err != nil {
+			t.Fatalf("glyph %d: %v", id, err)
+		}
+		return adv
+	}
+	kern := func(f *sfnt.Font, a, b sfnt.GlyphIndex) fixed.Int26_6 {
+		k, err := f.Kern(buf, a, b, fixed.I(64), font.HintingNone)
+		if err != nil && err != sfnt.ErrNotFound {
+			t.Fatalf("kern %d %d: %v", a, b, err)
+		}
+		return k
+	}
+
+	// Å and é are composites, so their components come along.
+	kept, dropped := "AVTo Bitcoin (BTC) Åé", "xyz%"
+	cmap := map[rune]uint16{}
+	for _, r := range kept {
+		cmap[r] = uint16(index(orig, r))
+	}
+	out, err := subsetSFNT(ttf, cmap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) > 8<<10 {
+		t.Errorf("subset is %d bytes, want a few KB", len(out))
+	}
+
+	f, err := sfnt.Parse(out)
+	if err != nil {
+		t.Fatalf("sfnt.Parse: %v", err)
+	}
+	if f.NumGlyphs() >= 32 {
+		t.Errorf("subset has %d glyphs, want only the ones used", f.NumGlyphs())
+	}
+	for _, r := range kept {
+		id, origID := index(f, r), index(orig, r)
+		if id == 0 {
+			t.Errorf("%q is not mapped", r)
+			continue
+		}
+		if got, want := outline(f, id), outline(orig, origID); got != want {
+			t.Errorf("outline of %q changed", r)
+		}
+		if got, want := advance(f, id), advance(orig, origID); got != want {
+			t.Errorf("advance of %q = %v, want %v", r, got, want)
+		}
+		for _, r2 := range kept {
+			if got, want := kern(f, id, index(f, r2)), kern(orig, origID, index(orig, r2)); got != want {
+				t.Errorf("kerning of %q%q = %v, want %v", r, r2, got, want)
+			}
+		}
+	}
+	for _, r := range dropped {
+		if id := index(f, r); id != 0 {
+			t.Errorf("%q maps to glyph %d, want it dropped", r, id)
+		}
+	}
+	gotName, _ := f.Name(buf, sfnt.NameIDFull)
+	wantName, _ := orig.Name(buf, sfnt.NameIDFull)
+	if gotName != wantName {
+		t.Errorf("name = %q, want %q", gotName, wantName)
+	}
+
+	_, tables, err := readSFNTTables(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for tag := range tables {
+		if !subsetTables[tag] && tag != "kern" {
+			t.Errorf("subset still has the %q table", tag)
+		}
+	}
+	parsed, err := canvasFont.ParseSFNT(out)
+	if err != nil {
+		t.Fatalf("canvas ParseSFNT: %v", err)
+	}
+	for r := range cmap {
+		if got, want := parsed.GlyphIndex(r), uint16(index(f, r)); got != want {
+			t.Errorf("canvas maps %q to %d, x/image to %d", r, got, want)
+		}
+	}
+	for id := 0; id < f.NumGlyphs(); id++ {
+		contour, err := parsed.GlyphContour(uint16(id))
+		if err != nil {
+			t.Fatalf("glyph %d: %v", id, err)
+		}
+		if contour != nil && len(contour.Instructions) > 0 {
+			t.Errorf("glyph %d keeps %d bytes of instructions", id, len(contour.Instructions))
+		}
+	}
+}
+
+// TestSubsetCmap maps runes past the Basic Multilingual Plane, which need a
+// format 12 subtable next to the format 4 one.
+func TestSubsetCmap(t *testing.T) {
+	orig, err := sfnt.Parse(goregular.TTF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &sfnt.Buffer{}
+	cmap := map[rune]uint16{}
+	for _, r := range "abcx" {
+		id, _ := orig.GlyphIndex(buf, r)
+		cmap[r] = uint16(id)
+	}
+	cmap[0xFFFD] = cmap['x']
+	cmap[0x1F600], cmap[0x1F601] = cmap['a'], cmap['b']
+	out, err := subsetSFNT(goregular.TTF, cmap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := sfnt.Parse(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := canvasFont.ParseSFNT(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aliases := map[rune]rune{0xFFFD: 'x', 0x1F600: 'a', 0x1F601: 'b'}
+	for r := range cmap {
+		got, err := f.GlyphIndex(buf, r)
+		if err != nil || got == 0 {
+			t.Errorf("x/image does not map %U: %v", r, err)
+		}
+		if alias, ok := aliases[r]; ok {
+			if want, _ := f.GlyphIndex(buf, alias); got != want {
+				t.Errorf("%U maps to %d, want %d like %q", r, got, want, alias)
+			}
+		}
+		if id := parsed.GlyphIndex(r); id != uint16(got) {
+			t.Errorf("canvas maps %U to %d, x/image to %d", r, id, got)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"github.com/tdewolff/canvas"
+	canvasFont "github.com/tdewolff/canvas/font"
+	"github.com/tdewolff/canvas/svg"
+	"io"
+	"regexp"
+)
+
+type svgRenderer struct {
+	*svg.SVG
+	fonts []*canvas.Font
+	text  map[*canvas.Font]string
+}
+
+func (r *svgRenderer) RenderText(text *canvas.Text, m canvas.Matrix) {
+	if optSVGText == "paths" {
+		renderTextAsPath(r.SVG, text, m)
+		return
+	}
+	text.WalkSpans(func(y, dx float64, span canvas.TextSpan) {
+		if _, ok := r.text[span.Face.Font]; !ok {
+			r.fonts = append(r.fonts, span.Face.Font)
+		}
+		r.text[span.Face.Font] += span.Text
+	})
+	r.SVG.RenderText(text, m)
+}
+
+// embeddedFont returns font subset to the glyphs of text, for embedding in svg
+// cards, or the whole font when it cannot be subset. Only svg cards are
+// subset: pdf cards embed the whole font through canvas's pdf writer.
+func embeddedFont(font *canvas.Font, text string) (mediatype string, raw []byte) {
+	mediatype, raw = font.Raw()
+	buf, err := canvasFont.ToSFNT(raw)
+	if err != nil {
+		return
+	}
+	cmap := map[rune]uint16{}
+	indices := font.IndicesOf(text)
+	for i, r := range []rune(text) {
+		if indices[i] != 0 {
+			cmap[r] = indices[i]
+		}
+	}
+	if buf, err = subsetSFNT(buf, cmap); err != nil {
+		return
+	}
+	return "font/ttf", buf
+}
+
+var svgSizeAttributes = regexp.MustCompile(` width="[^"]*" height="[^"]*"`)
+
+// svgResponsiveWriter drops the fixed width and height from the opening <svg>
+// tag, leaving its viewBox to scale the card to its container.
+type svgResponsiveWriter struct {
+	w      io.Writer
+	header []byte
+	done   bool
+}
+
+func (w *svgResponsiveWriter) Write(p []byte) (n int, err error) {
+	if w.done {
+		return w.w.Write(p)
+	}
+	w.header = append(w.header, p...)
+	i := bytes.IndexByte(w.header, '>')
+	if i < 0 {
+		return len(p), nil
+	}
+	w.done = true
+	tag := svgSizeAttributes.ReplaceAll(w.header[:i], []byte(` preserveAspectRatio="xMidYMid meet"`))
+	if _, err = w.w.Write(tag); err != nil {
+		return
+	}
+	if _, err = w.w.Write(w.header[i:]); err != nil {
+		return
+	}
+	w.header = nil
+	return len(p), nil
+}
+
+func (r *svgRenderer) writeFonts(w io.Writer) (err error) {
+	if len(r.fonts) == 0 {
+		return
+	}
+	if _, err = fmt.Fprint(w, "<style>"); err != nil {
+		return
+	}
+	for _, font := range r.fonts {
+		mediatype, raw := embeddedFont(font, r.text[font])
+		if _, err = fmt.Fprintf(w, "\n@font-face{font-family:'%s';src:url('data:%s;base64,", font.Name(), mediatype); err != nil {
+			return
+		}
+		enc := base64.NewEncoder(base64.StdEncoding, w)
+		if _, err = enc.Write(raw); err != nil {
+			return
+		}
+		if err = enc.Close(); err != nil {
+			return
+		}
+		if _, err = fmt.Fprint(w, "');}"); err != nil {
+			return
+		}
+	}
+	_, err = fmt.Fprint(w, "\n</style>")
+	return
+}
+
+// svgWriter writes the canvas as SVG, with text either as selectable <text>
+// in embedded fonts subset to the glyphs used on the card, or with -svg-text
+// paths as glyph outlines.
+func svgWriter(w io.Writer, c *canvas.Canvas) (err error) {
+	if optSVGResponsive {
+		w = &svgResponsiveWriter{w: w}
+	}
+	r := &svgRenderer{SVG: svg.New(w, c.W, c.H), text: map[*canvas.Font]string{}}
+	r.EmbedFonts(false)
+	c.Render(r)
+	if err = r.writeFonts(w); err != nil {
+		return
+	}
+	return r.Close()
+}
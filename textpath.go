@@ -0,0 +1,137 @@
+package main
+
+import (
+	"github.com/tdewolff/canvas"
+	"math"
+)
+
+// renderTextAsPath draws text as glyph outlines like Text.RenderAsPath, but
+// emboldens faux bold spans with emboldenPath rather than Path.Offset, which
+// guesses the filled side of each contour and grows counters as often as it
+// shrinks them.
+func renderTextAsPath(r canvas.Renderer, text *canvas.Text, m canvas.Matrix) {
+	style := canvas.DefaultStyle
+	text.WalkLines(func(y, dx float64, span canvas.TextSpan) {
+		bold := span.Face.FauxBold
+		span.Face.FauxBold = 0
+		p, _, col := span.ToPath(0)
+		style.FillColor = col
+		r.RenderPath(emboldenPath(p, bold).Translate(dx, y), style, m)
+	}, r.RenderPath, m)
+}
+
+// emboldenPath offsets the contours of the glyph outlines p by w, away from
+// the ink when w is positive and into it when negative. Fonts wind outlines
+// one way and counters the other, so each contour moves by its winding
+// against the largest one, which is always an outline: outlines grow as
+// counters shrink. Where an offset crosses itself, the loops that wind the
+// wrong way are dropped, and a counter too narrow to survive is closed up
+// rather than turned inside out. Outlines that grow into each other merge, so
+// gaps narrower than 2w, such as between the ring of @ and its a, fill in.
+func emboldenPath(p *canvas.Path, w float64) *canvas.Path {
+	if w == 0 {
+		return p
+	}
+	contours := p.Split()
+	areas := make([]float64, len(contours))
+	outline := 0.0
+	for i, contour := range contours {
+		areas[i] = polygonArea(contour.Flatten().Coords())
+		if math.Abs(areas[i]) > math.Abs(outline) {
+			outline = areas[i]
+		}
+	}
+
+	q := &canvas.Path{}
+	for i, contour := range contours {
+		if !contour.Closed() || areas[i] == 0 {
+			q = q.Append(contour)
+			continue
+		}
+		counter := (areas[i] > 0) != (outline > 0)
+		grow := (w > 0) != counter
+		if b := contour.Bounds(); !grow && 2*math.Abs(w) >= math.Min(b.W, b.H) {
+			continue
+		}
+		// A closed contour strokes to its offsets either side, the second
+		// reversed so that the two cancel out.
+		sides := contour.Stroke(2*math.Abs(w), canvas.ButtCap, canvas.RoundJoin).Split()
+		if len(sides) != 2 {
+			q = q.Append(contour)
+			continue
+		}
+		inner, outer := sides[0].Flatten().Coords(), sides[1].Reverse().Flatten().Coords()
+		if math.Abs(polygonArea(inner)) > math.Abs(polygonArea(outer)) {
+			inner, outer = outer, inner
+		}
+		side := inner
+		if grow {
+			side = outer
+		}
+		for _, loop := range simpleLoops(side) {
+			if area := polygonArea(loop); area != 0 && (area > 0) == (areas[i] > 0) {
+				q = q.Append(polygonPath(loop))
+			}
+		}
+	}
+	return q
+}
+
+// simpleLoops splits the closed polygon pts at the points where it crosses
+// itself, into loops that do not.
+func simpleLoops(pts []canvas.Point) [][]canvas.Point {
+	if n := len(pts); n > 1 && pts[0].Equals(pts[n-1]) {
+		pts = pts[:n-1]
+	}
+	n := len(pts)
+	for i := 0; i < n; i++ {
+		for j := i + 2; j < n; j++ {
+			if i == 0 && j == n-1 {
+				continue
+			}
+			if x, ok := segmentCrossing(pts[i], pts[i+1], pts[j], pts[(j+1)%n]); ok {
+				inside := append([]canvas.Point{x}, pts[i+1:j+1]...)
+				outside := append(append([]canvas.Point{x}, pts[j+1:]...), pts[:i+1]...)
+				return append(simpleLoops(inside), simpleLoops(outside)...)
+			}
+		}
+	}
+	return [][]canvas.Point{pts}
+}
+
+// segmentCrossing returns where the segments a0-a1 and b0-b1 cross, if they
+// do so away from their ends.
+func segmentCrossing(a0, a1, b0, b1 canvas.Point) (canvas.Point, bool) {
+	da, db := a1.Sub(a0), b1.Sub(b0)
+	denom := da.X*db.Y - da.Y*db.X
+	if denom == 0 {
+		return canvas.Point{}, false
+	}
+	d := b0.Sub(a0)
+	s := (d.X*db.Y - d.Y*db.X) / denom
+	t := (d.X*da.Y - d.Y*da.X) / denom
+	if s <= 0 || s >= 1 || t <= 0 || t >= 1 {
+		return canvas.Point{}, false
+	}
+	return a0.Add(da.Mul(s)), true
+}
+
+// polygonArea is the signed area of the closed polygon pts, positive when it
+// runs counter-clockwise.
+func polygonArea(pts []canvas.Point) (area float64) {
+	for i, a := range pts {
+		b := pts[(i+1)%len(pts)]
+		area += a.X*b.Y - b.X*a.Y
+	}
+	return area / 2
+}
+
+func polygonPath(pts []canvas.Point) *canvas.Path {
+	p := &canvas.Path{}
+	p.MoveTo(pts[0].X, pts[0].Y)
+	for _, pt := range pts[1:] {
+		p.LineTo(pt.X, pt.Y)
+	}
+	p.Close()
+	return p
+}
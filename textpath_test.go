@@ -0,0 +1,59 @@
+package main
+
+import (
+	"github.com/tdewolff/canvas"
+	"golang.org/x/image/font/gofont/goregular"
+	"math"
+	"testing"
+)
+
+func TestEmboldenPath(t *testing.T) {
+	family := canvas.NewFontFamily("test")
+	if err := family.LoadFont(goregular.TTF, canvas.FontRegular); err != nil {
+		t.Fatal(err)
+	}
+	face := family.Face(100, canvas.Black, canvas.FontRegular, canvas.FontNormal)
+	size := face.Size * face.Scale
+	tests := []struct {
+		glyph   string
+		bold    float64
+		x, y    float64 // a point in a counter, as a fraction of the glyph bounds
+		counter bool    // whether the counter stays open
+	}{
+		{"o", 0.02, 0.5, 0.5, true},
+		{"o", 0.04, 0.5, 0.5, true},
+		{"O", 0.06, 0.5, 0.5, true},
+		{"D", 0.04, 0.5, 0.5, true},
+		{"e", 0.04, 0.45, 0.7, true},
+		{"a", 0.04, 0.4, 0.3, true},
+		{"@", 0.04, 0.5, 0.6, true},
+		{"@", 0.02, 0.15, 0.5, true},
+		// The channel between the ring of @ and its a is narrower than twice
+		// the default offset of the boldest weights, so it fills in.
+		{"@", 0.04, 0.15, 0.5, false},
+		{"o", 0.25, 0.5, 0.5, false},
+	}
+	for _, tt := range tests {
+		p, _ := face.ToPath(tt.glyph)
+		w := tt.bold * size
+		bold := emboldenPath(p, w)
+
+		b, got := p.Bounds(), bold.Bounds()
+		for _, d := range []float64{b.X - got.X, b.Y - got.Y, got.W - b.W - w, got.H - b.H - w} {
+			if math.Abs(d-w) > w/20 {
+				t.Errorf("%q by %v: bounds %v do not grow %v from %v", tt.glyph, tt.bold, got, b, w)
+				break
+			}
+		}
+		if open := !bold.Interior(b.X+tt.x*b.W, b.Y+tt.y*b.H, canvas.NonZero); open != tt.counter {
+			t.Errorf("%q by %v: counter at %v,%v open = %v, want %v", tt.glyph, tt.bold, tt.x, tt.y, open, tt.counter)
+		}
+		inked := false
+		for f := 0.1; f < 1 && !inked; f += 0.1 {
+			inked = bold.Interior(b.X-w/2, b.Y+f*b.H, canvas.NonZero)
+		}
+		if !inked {
+			t.Errorf("%q by %v: no ink %v left of the outline", tt.glyph, tt.bold, w/2)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+// ValidationError reports an input or option that was rejected before rendering.
+type ValidationError struct {
+	Field  string
+	Value  string
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	value := e.Value
+	if utf8.RuneCountInString(value) > 32 {
+		value = string([]rune(value)[:32]) + "..."
+	}
+	return fmt.Sprintf("invalid %s %q: %s", e.Field, value, e.Reason)
+}
+
+const idCharset = "abcdefghijklmnopqrstuvwxyz0123456789-_"
+
+func validateItem(id, name, address string) error {
+	if id == "" {
+		return &ValidationError{Field: "id", Value: id, Reason: "must not be empty"}
+	}
+	if strings.Trim(id, idCharset) != "" {
+		return &ValidationError{Field: "id", Value: id, Reason: "must only contain lower case letters, digits, - and _"}
+	}
+	for _, f := range []struct{ field, value string }{{"id", id}, {"name", name}, {"address", address}} {
+		if !utf8.ValidString(f.value) {
+			return &ValidationError{Field: f.field, Value: f.value, Reason: "not valid UTF-8"}
+		}
+		if utf8.RuneCountInString(f.value) > optMaxInputLength {
+			return &ValidationError{Field: f.field, Value: f.value, Reason: fmt.Sprintf("longer than %d characters", optMaxInputLength)}
+		}
+	}
+	return nil
+}
+
+func validateOptions() error {
+	for _, format := range strings.Split(optFormats, ",") {
+		if _, ok := cardFormats[format]; !ok {
+			return &ValidationError{Field: "format", Value: format, Reason: "must be one of " + strings.Join(formatNames(), ", ")}
+		}
+	}
+	if _, ok := styles[optStyle]; !ok {
+		return &ValidationError{Field: "style", Value: optStyle, Reason: "must be one of " + strings.Join(styleNames(), ", ")}
+	}
+	if _, err := lookupPalette(optPalette); err != nil {
+		return &ValidationError{Field: "palette", Value: optPalette, Reason: "must be one of " + strings.Join(paletteNames(), ", ") + ", or a CSS color"}
+	}
+	if !patterns[optPattern] {
+		return &ValidationError{Field: "pattern", Value: optPattern, Reason: "must be none, stripes, dots, chevrons or split"}
+	}
+	if optAnimate != "" {
+		if _, ok := animationEncoders[optAnimate]; !ok {
+			return &ValidationError{Field: "animate", Value: optAnimate, Reason: "must be gif or apng"}
+		}
+		if !animations[optAnimation] {
+			return &ValidationError{Field: "animation", Value: optAnimation, Reason: "must be ring, ripple or pulse"}
+		}
+		if optFrames < 1 {
+			return &ValidationError{Field: "frames", Value: fmt.Sprint(optFrames), Reason: "must be at least 1"}
+		}
+	}
+	if optFontWeight < 1 || optFontWeight > 1000 {
+		return &ValidationError{Field: "font-weight", Value: fmt.Sprint(optFontWeight), Reason: "must be between 1 and 1000"}
+	}
+	if optFauxBold < 0 || optFauxBold > 0.1 {
+		return &ValidationError{Field: "faux-bold", Value: fmt.Sprint(optFauxBold), Reason: "must be between 0 and 0.1"}
+	}
+	if optFauxItalic < 0 || optFauxItalic >= 45 {
+		return &ValidationError{Field: "faux-italic", Value: fmt.Sprint(optFauxItalic), Reason: "must be at least 0 and less than 45"}
+	}
+	if optSVGText != "font" && optSVGText != "paths" {
+		return &ValidationError{Field: "svg-text", Value: optSVGText, Reason: "must be font or paths"}
+	}
+	switch optBadgeShape {
+	case "circle", "squircle", "polygon":
+	case "path":
+		if _, err := svgShape(1, optBadgePath); err != nil {
+			return &ValidationError{Field: "badge-path", Value: optBadgePath, Reason: err.Error()}
+		}
+	default:
+		return &ValidationError{Field: "badge-shape", Value: optBadgeShape, Reason: "must be circle, squircle, polygon or path"}
+	}
+	if optSquircleExponent <= 0 {
+		return &ValidationError{Field: "squircle-exponent", Value: fmt.Sprint(optSquircleExponent), Reason: "must be positive"}
+	}
+	if optSquircleRadius < 0 || optSquircleRadius > 1 {
+		return &ValidationError{Field: "squircle-radius", Value: fmt.Sprint(optSquircleRadius), Reason: "must be between 0 and 1"}
+	}
+	if optCornerSmoothing < 0 || optCornerSmoothing > 1 {
+		return &ValidationError{Field: "squircle-smoothing", Value: fmt.Sprint(optCornerSmoothing), Reason: "must be between 0 and 1"}
+	}
+	if optPolygonSides < 3 {
+		return &ValidationError{Field: "polygon-sides", Value: fmt.Sprint(optPolygonSides), Reason: "must be at least 3"}
+	}
+	if optPolygonRounding < 0 {
+		return &ValidationError{Field: "polygon-rounding", Value: fmt.Sprint(optPolygonRounding), Reason: "must not be negative"}
+	}
+	if _, ok := watermarkCorners[optWatermarkCorner]; !ok {
+		return &ValidationError{Field: "watermark-corner", Value: optWatermarkCorner, Reason: "must be top-left, top-right, bottom-left or bottom-right"}
+	}
+	if optWatermarkWidth <= 0 {
+		return &ValidationError{Field: "watermark-width", Value: fmt.Sprint(optWatermarkWidth), Reason: "must be positive"}
+	}
+	if optWatermarkOpacity < 0 || optWatermarkOpacity > 1 {
+		return &ValidationError{Field: "watermark-opacity", Value: fmt.Sprint(optWatermarkOpacity), Reason: "must be between 0 and 1"}
+	}
+	if optResolution <= 0 {
+		return &ValidationError{Field: "resolution", Value: fmt.Sprint(optResolution), Reason: "must be positive"}
+	}
+	if optSupersample < 1 {
+		return &ValidationError{Field: "supersample", Value: fmt.Sprint(optSupersample), Reason: "must be at least 1"}
+	}
+	if _, ok := resamplers[optResample]; !ok {
+		return &ValidationError{Field: "resample", Value: optResample, Reason: "must be nearest, bilinear, catmull-rom or lanczos"}
+	}
+	if side := math.Max(layout.Width, layout.Height) * optResolution * float64(optSupersample); side > float64(optMaxPixels) {
+		return &ValidationError{Field: "resolution", Value: fmt.Sprint(optResolution), Reason: fmt.Sprintf("renders %.0f pixels with -supersample %d, more than %d", side, optSupersample, optMaxPixels)}
+	}
+	if optShadowOpacity < 0 || optShadowOpacity > 1 {
+		return &ValidationError{Field: "shadow-opacity", Value: fmt.Sprint(optShadowOpacity), Reason: "must be between 0 and 1"}
+	}
+	if optGrain < 0 || optGrain > 1 {
+		return &ValidationError{Field: "grain", Value: fmt.Sprint(optGrain), Reason: "must be between 0 and 1"}
+	}
+	if optPNGColors < 0 || optPNGColors > 256 {
+		return &ValidationError{Field: "png-colors", Value: fmt.Sprint(optPNGColors), Reason: "must be between 0 and 256"}
+	}
+	if _, ok := pngCompressionLevels[optPNGCompression]; !ok {
+		return &ValidationError{Field: "png-compression", Value: optPNGCompression, Reason: "must be default, none, speed or best"}
+	}
+	if optJPEGQuality < 1 || optJPEGQuality > 100 {
+		return &ValidationError{Field: "jpeg-quality", Value: fmt.Sprint(optJPEGQuality), Reason: "must be between 1 and 100"}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/tdewolff/canvas"
+	"gopkg.in/yaml.v2"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// readAddressRows lists the cards of src/addresses.yml, tokens included.
+func readAddressRows() (rows []batchRow, err error) {
+	name := filepath.Join("src", "addresses.yml")
+	var buf []byte
+	if buf, err = ioutil.ReadFile(name); err != nil {
+		return
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(buf))
+	for {
+		var item Item
+		if err = dec.Decode(&item); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return
+		}
+		for _, token := range item.Tokens {
+			rows = append(rows, batchRow{File: name, ID: token.ID, Name: token.Name, Address: item.Address})
+		}
+		rows = append(rows, batchRow{File: name, ID: item.ID, Name: item.Name, Address: item.Address})
+	}
+}
+
+// warmCard renders every format of row into cardCache, skipping formats that
+// are already cached.
+func warmCard(row batchRow) (err error) {
+	id, name, address := normalizeItem(row.ID, row.Name, row.Address)
+	if err = validateItem(id, name, address); err != nil {
+		return
+	}
+	formats := optFormats
+	if row.Formats != "" {
+		formats = row.Formats
+	}
+	var c *canvas.Canvas
+	for _, format := range strings.Split(formats, ",") {
+		if _, ok := cardFormats[format]; !ok {
+			return &ValidationError{Field: "format", Value: format, Reason: "must be one of " + strings.Join(formatNames(), ", ")}
+		}
+		if err = renderCard(ioutil.Discard, &c, id, name, address, format); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// warmRows renders the rows into the card cache ahead of time, calling progress
+// after each row. It needs a persistent cache, since cards warmed only into
+// memory are gone when the process exits.
+func warmRows(ctx context.Context, rows []batchRow, workers int, progress func(done, total int, row batchRow, err error)) (failures []string, err error) {
+	if !persistentCache() {
+		return nil, errors.New("no persistent cache to warm, set -cache-dir or -memcached")
+	}
+	return renderRows(ctx, rows, workers, warmCard, progress)
+}
+
+func warm(args []string) (err error) {
+	fset := flag.NewFlagSet("warm", flag.ExitOnError)
+	workers := fset.Int("workers", runtime.NumCPU(), "number of concurrent renders")
+	if err = fset.Parse(args); err != nil {
+		return
+	}
+	if *workers < 1 {
+		return errors.New("warm: workers must be positive")
+	}
+
+	var rows []batchRow
+	if fset.NArg() == 0 {
+		if rows, err = readAddressRows(); err != nil {
+			return
+		}
+	}
+	for _, name := range fset.Args() {
+		var fileRows []batchRow
+		if fileRows, err = readBatch(name); err != nil {
+			return
+		}
+		rows = append(rows, fileRows...)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	progress, finish := rowProgress("warm")
+	var failures []string
+	failures, err = warmRows(ctx, rows, *workers, progress)
+	finish()
+	if optStats {
+		log.Println("stats total", statsSnapshot())
+	}
+	if err != nil {
+		return fmt.Errorf("warm: %w", err)
+	}
+	if len(failures) == 0 {
+		log.Printf("warm: cached %d cards", len(rows))
+		return
+	}
+	for _, failure := range failures {
+		log.Println("warm: failed", failure)
+	}
+	return fmt.Errorf("warm: %d of %d cards failed", len(failures), len(rows))
+}
@@ -0,0 +1,78 @@
+//go:build js && wasm
+// +build js,wasm
+
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"github.com/tdewolff/canvas"
+	"image"
+	"image/png"
+	"syscall/js"
+)
+
+func init() {
+	jsMain = runJS
+}
+
+// customFont is the font the command line uses by default, embedded since
+// there is no file system to load fonts from.
+//
+//go:embed src/custom-font.ttf
+var customFont []byte
+
+// runJS exposes persona.render(name, address, logo, format) to JavaScript,
+// where logo is a Uint8Array of PNG data and format defaults to png. It
+// returns a Uint8Array of the card, or an Error. Cards are set in
+// src/custom-font.ttf, as with the command line defaults.
+func runJS() {
+	fontFamily = canvas.NewFontFamily("Custom")
+	fontFamily.Use(canvas.CommonLigatures)
+	if err := fontFamily.LoadFont(customFont, canvas.FontRegular); err != nil {
+		panic(err)
+	}
+	js.Global().Set("persona", js.ValueOf(map[string]interface{}{
+		"render": js.FuncOf(jsRender),
+	}))
+	select {}
+}
+
+func jsRender(this js.Value, args []js.Value) interface{} {
+	if len(args) < 3 {
+		return js.Global().Get("Error").New("persona.render(name, address, logo[, format])")
+	}
+	format := "png"
+	if len(args) > 3 && args[3].Type() == js.TypeString {
+		format = args[3].String()
+	}
+	_, name, address := normalizeItem("", args[0].String(), args[1].String())
+	buf, err := renderJS(name, address, args[2], format)
+	if err != nil {
+		return js.Global().Get("Error").New(err.Error())
+	}
+	out := js.Global().Get("Uint8Array").New(len(buf))
+	js.CopyBytesToJS(out, buf)
+	return out
+}
+
+func renderJS(name, address string, logoData js.Value, format string) (buf []byte, err error) {
+	if err = validateItem("js", name, address); err != nil {
+		return
+	}
+	b := make([]byte, logoData.Get("length").Int())
+	js.CopyBytesToGo(b, logoData)
+	var logo image.Image
+	if logo, err = png.Decode(bytes.NewReader(b)); err != nil {
+		return
+	}
+	var c *canvas.Canvas
+	out := &bytes.Buffer{}
+	if err = writeCard(out, &c, func() (*canvas.Canvas, error) {
+		return composeCard(logo, name, address)
+	}, format); err != nil {
+		return
+	}
+	buf = out.Bytes()
+	return
+}
@@ -0,0 +1,478 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"github.com/tdewolff/canvas"
+	"image"
+	"image/color"
+	"io"
+	"math/bits"
+	"sort"
+)
+
+func webpWriter(ctx context.Context, w io.Writer, c *canvas.Canvas) error {
+	img, err := rasterizeCard(ctx, c)
+	if err != nil {
+		return err
+	}
+	defer putRGBA(img)
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	return encodeWebP(w, img)
+}
+
+const (
+	webpMaxSize     = 1 << 14
+	webpMinMatch    = 3
+	webpMaxMatch    = 4096
+	webpMaxDistance = 1<<20 - 120
+	webpHashBits    = 16
+	webpChainLength = 32
+)
+
+// webpDistanceCodes maps the pixel offsets {dx, dy} of nearby pixels to the
+// short distance codes VP8L gives them, as listed in section 4.2.2 of the
+// WebP lossless bitstream specification.
+var webpDistanceCodes = func() map[[2]int]int {
+	table := [120]uint8{
+		0x18, 0x07, 0x17, 0x19, 0x28, 0x06, 0x27, 0x29, 0x16, 0x1a,
+		0x26, 0x2a, 0x38, 0x05, 0x37, 0x39, 0x15, 0x1b, 0x36, 0x3a,
+		0x25, 0x2b, 0x48, 0x04, 0x47, 0x49, 0x14, 0x1c, 0x35, 0x3b,
+		0x46, 0x4a, 0x24, 0x2c, 0x58, 0x45, 0x4b, 0x34, 0x3c, 0x03,
+		0x57, 0x59, 0x13, 0x1d, 0x56, 0x5a, 0x23, 0x2d, 0x44, 0x4c,
+		0x55, 0x5b, 0x33, 0x3d, 0x68, 0x02, 0x67, 0x69, 0x12, 0x1e,
+		0x66, 0x6a, 0x22, 0x2e, 0x54, 0x5c, 0x43, 0x4d, 0x65, 0x6b,
+		0x32, 0x3e, 0x78, 0x01, 0x77, 0x79, 0x53, 0x5d, 0x11, 0x1f,
+		0x64, 0x6c, 0x42, 0x4e, 0x76, 0x7a, 0x21, 0x2f, 0x75, 0x7b,
+		0x31, 0x3f, 0x63, 0x6d, 0x52, 0x5e, 0x00, 0x74, 0x7c, 0x41,
+		0x4f, 0x10, 0x20, 0x62, 0x6e, 0x30, 0x73, 0x7d, 0x51, 0x5f,
+		0x40, 0x72, 0x7e, 0x61, 0x6f, 0x50, 0x71, 0x7f, 0x60, 0x70,
+	}
+	codes := map[[2]int]int{}
+	for i, v := range table {
+		codes[[2]int{8 - int(v&0xf), int(v >> 4)}] = i + 1
+	}
+	return codes
+}()
+
+// webpDistanceCode returns the VP8L distance code for a backward reference
+// dist pixels back in an image width pixels wide.
+func webpDistanceCode(dist, width int) int {
+	dy, dx := dist/width, dist%width
+	code := dist + len(webpDistanceCodes)
+	if c, ok := webpDistanceCodes[[2]int{dx, dy}]; ok && c < code {
+		code = c
+	}
+	if c, ok := webpDistanceCodes[[2]int{dx - width, dy + 1}]; ok && c < code {
+		code = c
+	}
+	return code
+}
+
+// webpPrefix splits a VP8L length or distance code v into its prefix symbol
+// and the extra bits that follow it.
+func webpPrefix(v int) (symbol int, n uint, extra uint32) {
+	d := v - 1
+	if d < 4 {
+		return d, 0, 0
+	}
+	hb := bits.Len(uint(d)) - 1
+	n = uint(hb - 1)
+	return 2*hb + (d>>n)&1, n, uint32(d) & (1<<n - 1)
+}
+
+// webpToken is a literal ARGB pixel, or a backward reference copying length
+// pixels from code, a distance code, when length is not zero.
+type webpToken struct {
+	argb   uint32
+	length int
+	code   int
+}
+
+// encodeWebP writes img as a lossless WebP. The pixels go through the
+// subtract green transform and LZ77 with hash chains, and are coded with one
+// group of prefix codes for the whole image.
+func encodeWebP(w io.Writer, img image.Image) error {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if width < 1 || height < 1 || width > webpMaxSize || height > webpMaxSize {
+		return errors.New("webp: image must be 1 to 16384 pixels on each side")
+	}
+	pix := make([]uint32, 0, width*height)
+	alpha := false
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			alpha = alpha || c.A != 255
+			// subtract green
+			r, bl := c.R-c.G, c.B-c.G
+			pix = append(pix, uint32(c.A)<<24|uint32(r)<<16|uint32(c.G)<<8|uint32(bl))
+		}
+	}
+
+	tokens := webpBackwardReferences(pix, width)
+	var green [256 + 24]int
+	var red, blue, alphas [256]int
+	var dist [40]int
+	for _, t := range tokens {
+		if t.length == 0 {
+			green[t.argb>>8&0xff]++
+			red[t.argb>>16&0xff]++
+			blue[t.argb&0xff]++
+			alphas[t.argb>>24]++
+			continue
+		}
+		s, _, _ := webpPrefix(t.length)
+		green[256+s]++
+		s, _, _ = webpPrefix(t.code)
+		dist[s]++
+	}
+
+	bw := &bitWriter{}
+	bw.write(0x2f, 8)
+	bw.write(uint32(width-1), 14)
+	bw.write(uint32(height-1), 14)
+	if alpha {
+		bw.write(1, 1)
+	} else {
+		bw.write(0, 1)
+	}
+	bw.write(0, 3) // version
+	bw.write(1, 1) // a transform follows:
+	bw.write(2, 2) // subtract green
+	bw.write(0, 1) // no more transforms
+	bw.write(0, 1) // no color cache
+	bw.write(0, 1) // one group of prefix codes
+	codes := [5]prefixCode{
+		bw.writePrefixCode(green[:]),
+		bw.writePrefixCode(red[:]),
+		bw.writePrefixCode(blue[:]),
+		bw.writePrefixCode(alphas[:]),
+		bw.writePrefixCode(dist[:]),
+	}
+	for _, t := range tokens {
+		if t.length == 0 {
+			codes[0].write(bw, int(t.argb>>8&0xff))
+			codes[1].write(bw, int(t.argb>>16&0xff))
+			codes[2].write(bw, int(t.argb&0xff))
+			codes[3].write(bw, int(t.argb>>24))
+			continue
+		}
+		s, n, extra := webpPrefix(t.length)
+		codes[0].write(bw, 256+s)
+		bw.write(extra, n)
+		s, n, extra = webpPrefix(t.code)
+		codes[4].write(bw, s)
+		bw.write(extra, n)
+	}
+	data := bw.flush()
+
+	size := len(data) + len(data)&1
+	header := make([]byte, 20)
+	copy(header, "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], uint32(4+8+size))
+	copy(header[8:], "WEBPVP8L")
+	binary.LittleEndian.PutUint32(header[16:], uint32(len(data)))
+	if len(data)&1 != 0 {
+		data = append(data, 0)
+	}
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// webpBackwardReferences turns pix into literals and backward references,
+// taking the longest match among the pixel to the left, the one above and
+// the last webpChainLength places the next two pixels were seen.
+func webpBackwardReferences(pix []uint32, width int) (tokens []webpToken) {
+	head := make([]int32, 1<<webpHashBits)
+	for i := range head {
+		head[i] = -1
+	}
+	prev := make([]int32, len(pix))
+	hash := func(i int) uint32 {
+		return (pix[i]*0x1e35a7bd ^ pix[i+1]*0x9e3779b1) >> (32 - webpHashBits)
+	}
+	insert := func(i int) {
+		if i+1 < len(pix) {
+			h := hash(i)
+			prev[i], head[h] = head[h], int32(i)
+		}
+	}
+	match := func(i, j int) (n int) {
+		for n < webpMaxMatch && i+n < len(pix) && pix[i+n] == pix[j+n] {
+			n++
+		}
+		return
+	}
+
+	for i := 0; i < len(pix); {
+		best, bestDist := 0, 0
+		try := func(dist int) {
+			if dist < 1 || dist > i || dist > webpMaxDistance {
+				return
+			}
+			if n := match(i, i-dist); n > best || n == best && n > 0 && webpDistanceCode(dist, width) < webpDistanceCode(bestDist, width) {
+				best, bestDist = n, dist
+			}
+		}
+		try(1)
+		try(width)
+		if i+1 < len(pix) {
+			for j, k := head[hash(i)], 0; j >= 0 && k < webpChainLength && best < webpMaxMatch; j, k = prev[j], k+1 {
+				try(i - int(j))
+			}
+		}
+		if best < webpMinMatch {
+			tokens = append(tokens, webpToken{argb: pix[i]})
+			insert(i)
+			i++
+			continue
+		}
+		tokens = append(tokens, webpToken{length: best, code: webpDistanceCode(bestDist, width)})
+		for end := i + best; i < end; i++ {
+			insert(i)
+		}
+	}
+	return
+}
+
+type bitWriter struct {
+	buf  []byte
+	bits uint64
+	n    uint
+}
+
+// write appends the low n bits of v, least significant first.
+func (b *bitWriter) write(v uint32, n uint) {
+	b.bits |= uint64(v) << b.n
+	b.n += n
+	for b.n >= 8 {
+		b.buf = append(b.buf, byte(b.bits))
+		b.bits >>= 8
+		b.n -= 8
+	}
+}
+
+func (b *bitWriter) flush() []byte {
+	if b.n > 0 {
+		b.buf = append(b.buf, byte(b.bits))
+		b.bits, b.n = 0, 0
+	}
+	return b.buf
+}
+
+// prefixCode holds the canonical codes of an alphabet, bit-reversed to be
+// written least significant bit first.
+type prefixCode struct {
+	codes   []uint16
+	lengths []uint8
+}
+
+func (c prefixCode) write(b *bitWriter, symbol int) {
+	b.write(uint32(c.codes[symbol]), uint(c.lengths[symbol]))
+}
+
+// newPrefixCode assigns canonical codes to lengths. A code of one symbol is
+// written with no bits at all.
+func newPrefixCode(lengths []uint8) prefixCode {
+	c := prefixCode{codes: make([]uint16, len(lengths)), lengths: append([]uint8{}, lengths...)}
+	var count [16]int
+	used := 0
+	for _, l := range lengths {
+		if l > 0 {
+			count[l]++
+			used++
+		}
+	}
+	if used == 1 {
+		for i := range c.lengths {
+			c.lengths[i] = 0
+		}
+		return c
+	}
+	var next [16]int
+	for l, code := 1, 0; l < 16; l++ {
+		code = (code + count[l-1]) << 1
+		next[l] = code
+	}
+	for s, l := range lengths {
+		if l > 0 {
+			c.codes[s] = bits.Reverse16(uint16(next[l])) >> (16 - l)
+			next[l]++
+		}
+	}
+	return c
+}
+
+// huffmanLengths returns code lengths of at most limit bits for symbols
+// occurring freq times, halving the counts until the tree is shallow enough.
+func huffmanLengths(freq []int, limit int) []uint8 {
+	type node struct {
+		weight, parent int
+	}
+	weights := append([]int{}, freq...)
+	for {
+		var nodes []node
+		var leaves []int
+		for s, f := range weights {
+			if f > 0 {
+				leaves = append(leaves, s)
+			}
+		}
+		lengths := make([]uint8, len(freq))
+		if len(leaves) == 1 {
+			lengths[leaves[0]] = 1
+			return lengths
+		}
+		sort.SliceStable(leaves, func(i, j int) bool { return weights[leaves[i]] < weights[leaves[j]] })
+		for _, s := range leaves {
+			nodes = append(nodes, node{weight: weights[s], parent: -1})
+		}
+		// Two queues: the sorted leaves and the merged nodes, which are
+		// made in order of weight.
+		li, mi := 0, len(nodes)
+		pop := func() int {
+			if li < len(leaves) && (mi >= len(nodes) || nodes[li].weight <= nodes[mi].weight) {
+				li++
+				return li - 1
+			}
+			mi++
+			return mi - 1
+		}
+		for n := len(leaves); n > 1; n-- {
+			a, b := pop(), pop()
+			nodes = append(nodes, node{weight: nodes[a].weight + nodes[b].weight, parent: -1})
+			nodes[a].parent, nodes[b].parent = len(nodes)-1, len(nodes)-1
+		}
+		deepest := 0
+		for i, s := range leaves {
+			depth := 0
+			for p := nodes[i].parent; p >= 0; p = nodes[p].parent {
+				depth++
+			}
+			lengths[s] = uint8(depth)
+			if depth > deepest {
+				deepest = depth
+			}
+		}
+		if deepest <= limit {
+			return lengths
+		}
+		for s, f := range weights {
+			if f > 0 {
+				weights[s] = (f + 1) / 2
+			}
+		}
+	}
+}
+
+var codeLengthCodeOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// writePrefixCode writes the prefix code for symbols occurring freq times,
+// as a simple code when it has at most two symbols below 256, and returns it.
+func (b *bitWriter) writePrefixCode(freq []int) prefixCode {
+	var used []int
+	for s, f := range freq {
+		if f > 0 {
+			used = append(used, s)
+		}
+	}
+	if len(used) <= 2 && (len(used) == 0 || used[len(used)-1] < 256) {
+		lengths := make([]uint8, len(freq))
+		b.write(1, 1)
+		if len(used) == 0 {
+			used = []int{0}
+		}
+		b.write(uint32(len(used)-1), 1)
+		if used[0] < 2 {
+			b.write(0, 1)
+			b.write(uint32(used[0]), 1)
+		} else {
+			b.write(1, 1)
+			b.write(uint32(used[0]), 8)
+		}
+		if len(used) == 2 {
+			b.write(uint32(used[1]), 8)
+			lengths[used[0]], lengths[used[1]] = 1, 1
+		}
+		return newPrefixCode(lengths)
+	}
+
+	lengths := huffmanLengths(freq, 15)
+	// Run-length code the lengths with the repeat codes 16 to 18.
+	type token struct {
+		symbol int
+		extra  uint32
+	}
+	var tokens []token
+	for i := 0; i < len(lengths); {
+		v, n := lengths[i], 1
+		for i+n < len(lengths) && lengths[i+n] == v {
+			n++
+		}
+		i += n
+		if v == 0 {
+			for n >= 11 {
+				k := n
+				if k > 138 {
+					k = 138
+				}
+				tokens = append(tokens, token{18, uint32(k - 11)})
+				n -= k
+			}
+			if n >= 3 {
+				tokens = append(tokens, token{17, uint32(n - 3)})
+				n = 0
+			}
+		} else {
+			tokens = append(tokens, token{int(v), 0})
+			for n--; n >= 3; {
+				k := n
+				if k > 6 {
+					k = 6
+				}
+				tokens = append(tokens, token{16, uint32(k - 3)})
+				n -= k
+			}
+		}
+		for ; n > 0; n-- {
+			tokens = append(tokens, token{int(v), 0})
+		}
+	}
+	var clFreq [19]int
+	for _, t := range tokens {
+		clFreq[t.symbol]++
+	}
+	clLengths := huffmanLengths(clFreq[:], 7)
+	count := 4
+	for i, s := range codeLengthCodeOrder {
+		if clLengths[s] > 0 && i+1 > count {
+			count = i + 1
+		}
+	}
+	b.write(0, 1)
+	b.write(uint32(count-4), 4)
+	for _, s := range codeLengthCodeOrder[:count] {
+		b.write(uint32(clLengths[s]), 3)
+	}
+	b.write(0, 1) // code lengths for the whole alphabet follow
+	cl := newPrefixCode(clLengths)
+	for _, t := range tokens {
+		cl.write(b, t.symbol)
+		switch t.symbol {
+		case 16:
+			b.write(t.extra, 2)
+		case 17:
+			b.write(t.extra, 3)
+		case 18:
+			b.write(t.extra, 7)
+		}
+	}
+	return newPrefixCode(lengths)
+}
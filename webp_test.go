@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"golang.org/x/image/webp"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math/rand"
+	"testing"
+)
+
+// TestEncodeWebP round trips images through encodeWebP and the x/image
+// decoder, which must give back every pixel.
+func TestEncodeWebP(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	noise := image.NewNRGBA(image.Rect(0, 0, 37, 23))
+	rnd.Read(noise.Pix)
+	flat := image.NewRGBA(image.Rect(0, 0, 300, 200))
+	draw.Draw(flat, flat.Bounds(), image.NewUniform(color.RGBA{R: 30, G: 144, B: 255, A: 255}), image.Point{}, draw.Src)
+	draw.Draw(flat, image.Rect(40, 50, 90, 190), image.NewUniform(color.RGBA{R: 64, G: 32, A: 128}), image.Point{}, draw.Over)
+	tests := []struct {
+		name string
+		img  image.Image
+	}{
+		{"one pixel", image.NewNRGBA(image.Rect(0, 0, 1, 1))},
+		{"noise", noise},
+		{"flat with a translucent box", flat},
+		{"offset bounds", flat.SubImage(image.Rect(10, 20, 150, 120))},
+		{"gray", image.NewGray(image.Rect(0, 0, 5, 700))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			if err := encodeWebP(buf, tt.img); err != nil {
+				t.Fatal(err)
+			}
+			got, err := webp.Decode(buf)
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			b := tt.img.Bounds()
+			if got.Bounds().Size() != b.Size() {
+				t.Fatalf("size = %v, want %v", got.Bounds().Size(), b.Size())
+			}
+			for y := 0; y < b.Dy(); y++ {
+				for x := 0; x < b.Dx(); x++ {
+					want := color.NRGBAModel.Convert(tt.img.At(b.Min.X+x, b.Min.Y+y))
+					if c := color.NRGBAModel.Convert(got.At(x, y)); c != want {
+						t.Fatalf("pixel %d,%d = %v, want %v", x, y, c, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestWebPCard(t *testing.T) {
+	setupTestOptions(t)
+	logo, err := loadLogo("bitcoin-btc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := composeCard(logo, "Bitcoin", "1BoatSLRHtKNngkdXEeobR76b53LETtpyT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lossless, ref := &bytes.Buffer{}, &bytes.Buffer{}
+	if err = cardFormats["webp"].Writer(context.Background(), lossless, c); err != nil {
+		t.Fatal(err)
+	}
+	if err = cardFormats["png"].Writer(context.Background(), ref, c); err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("webp %d bytes, png %d bytes", lossless.Len(), ref.Len())
+	got, err := webp.Decode(lossless)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := png.Decode(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := want.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if g, w := color.NRGBAModel.Convert(got.At(x, y)), color.NRGBAModel.Convert(want.At(x, y)); g != w {
+				t.Fatalf("pixel %d,%d = %v, want %v as in the png", x, y, g, w)
+			}
+		}
+	}
+}